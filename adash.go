@@ -5,13 +5,20 @@ import (
 	"flag"
 	"fmt"
 	_ "github.com/altinity/altinity-dashboard/internal/api"
+	"github.com/altinity/altinity-dashboard/internal/certs"
+	"github.com/altinity/altinity-dashboard/internal/config"
+	"github.com/altinity/altinity-dashboard/internal/logging"
 	"github.com/altinity/altinity-dashboard/internal/server"
 	"github.com/altinity/altinity-dashboard/internal/utils"
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 	"log"
 	"os"
 	"os/exec"
+	"os/signal"
 	"runtime"
+	"strings"
+	"syscall"
+	"time"
 )
 
 // App version info
@@ -19,10 +26,12 @@ var appVersion string
 var chopRelease string
 
 // UI embedded files
+//
 //go:embed ui/dist
 var uiFiles embed.FS
 
 // ClickHouse Operator deployment template embedded file
+//
 //go:embed embed
 var embedFiles embed.FS
 
@@ -45,26 +54,100 @@ func openWebBrowser(url string) {
 	}
 }
 
+// rotateTokens replaces the login token whenever SIGHUP is received, and also periodically if interval is non-zero
+func rotateTokens(c *server.Config, interval time.Duration) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-hup:
+		case <-tick:
+		}
+		err := c.RotateToken()
+		if err != nil {
+			log.Printf("Error rotating login token: %s\n", err)
+			continue
+		}
+		log.Printf("Login token rotated.  Connect using: %s\n", c.URL)
+	}
+}
+
 func main() {
 	// Set up CLI parser
 	cmdFlags := flag.NewFlagSet("adash", flag.ContinueOnError)
-	kubeconfig := cmdFlags.String("kubeconfig", "", "path to the kubeconfig file")
+	configFile := cmdFlags.String("config", "", "YAML file to read settings from (env: ADASH_CONFIG)")
+	kubeconfig := cmdFlags.String("kubeconfig", "", "path to the kubeconfig file, or several separated as in $KUBECONFIG")
+	kubeContext := cmdFlags.String("context", "", "kubeconfig context to use (default is the kubeconfig's current context)")
+	clusters := cmdFlags.String("clusters", "", "comma-separated clusters to manage, each as name=kubeconfig#context (the first is the default)")
 	devMode := cmdFlags.Bool("devmode", false, "show Developer Tools tab")
 	bindHost := cmdFlags.String("bindhost", "localhost", "host to bind to (use 0.0.0.0 for all interfaces)")
 	bindPort := cmdFlags.String("bindport", "", "port to listen on")
+	basePath := cmdFlags.String("basepath", "", "URL path prefix to serve the dashboard under, e.g. /clickhouse-dashboard")
 	tlsCert := cmdFlags.String("tlscert", "", "certificate file to use to serve TLS")
 	tlsKey := cmdFlags.String("tlskey", "", "private key file to use to serve TLS")
+	tlsClientCA := cmdFlags.String("tlsclientca", "", "CA certificate file to verify TLS client certificates against")
+	tlsClientAuth := cmdFlags.String("tlsclientauth", "optional", "whether client certificates are optional or required (optional, require)")
 	selfSigned := cmdFlags.Bool("selfsigned", false, "run TLS using self-signed key")
+	certDir := cmdFlags.String("certdir", "", "directory to keep the self-signed CA in (default is the user's config directory)")
 	noToken := cmdFlags.Bool("notoken", false, "do not require an auth token to access the UI")
+	oidcIssuer := cmdFlags.String("oidcissuer", "", "OpenID Connect issuer URL to log users in with (replaces the auth token)")
+	oidcClientID := cmdFlags.String("oidcclientid", "", "OpenID Connect client ID")
+	oidcClientSecret := cmdFlags.String("oidcclientsecret", "", "OpenID Connect client secret, if the client is confidential")
+	oidcScopes := cmdFlags.String("oidcscopes", "openid,profile,email", "comma-separated OpenID Connect scopes to request")
+	oidcRedirectURL := cmdFlags.String("oidcredirecturl", "", "OpenID Connect redirect URL (default is the server URL + /auth/callback)")
+	oidcUsernameClaim := cmdFlags.String("oidcusernameclaim", "email", "ID token claim to use as the username")
+	oidcGroupsClaim := cmdFlags.String("oidcgroupsclaim", "groups", "ID token claim to use as the user's groups")
+	policyFile := cmdFlags.String("policyfile", "", "YAML file mapping users and groups to roles (default is to make everyone an admin)")
+	impersonate := cmdFlags.Bool("impersonate", false, "make Kubernetes requests as the logged-in user rather than as the dashboard")
+	auditLog := cmdFlags.String("auditlog", "", "file to append the audit log to, or - for stdout (default is to keep it in memory only)")
+	tokensFile := cmdFlags.String("apitokensfile", "", "file to store API tokens in")
+	tokensSecret := cmdFlags.String("apitokenssecret", "", "Kubernetes secret to store API tokens in, as namespace/name")
+	tokenReview := cmdFlags.Bool("tokenreview", false, "authenticate bearer tokens with the Kubernetes TokenReview API and authorize requests with SubjectAccessReviews")
+	sessionIdle := cmdFlags.Duration("sessionidle", time.Hour, "log out browser sessions after this much inactivity (0 for never)")
+	sessionMax := cmdFlags.Duration("sessionmax", 12*time.Hour, "log out browser sessions this long after login (0 for never)")
+	tokenRotate := cmdFlags.Duration("tokenrotate", 0, "replace the login token at this interval (0 for only on SIGHUP)")
+	shutdownTimeout := cmdFlags.Duration("shutdowntimeout", 30*time.Second, "how long to wait for in-flight requests to finish when shutting down")
+	noCache := cmdFlags.Bool("nocache", false, "read from the Kubernetes API on every request instead of from an informer cache")
+	cacheSyncTimeout := cmdFlags.Duration("cachesynctimeout", 30*time.Second, "how long to wait at startup for the informer cache to sync")
+	noMetrics := cmdFlags.Bool("nometrics", false, "do not serve Prometheus metrics")
+	metricsAddr := cmdFlags.String("metricsaddr", "", "host:port to serve Prometheus metrics on, instead of the dashboard's own port")
+	defaultNamespace := cmdFlags.String("defaultnamespace", "", "namespace preselected in the UI when deploying operators and creating ClickHouse Installations")
+	operatorVersion := cmdFlags.String("operatorversion", "", "clickhouse-operator version to deploy when none is chosen (default is the bundled release)")
 	openBrowser := cmdFlags.Bool("openbrowser", false, "open the UI in a web browser after starting")
 	version := cmdFlags.Bool("version", false, "show version and exit")
-	debug := cmdFlags.Bool("debug", false, "enable debug logging")
+	debug := cmdFlags.Bool("debug", false, "enable debug logging (same as -loglevel debug)")
+	logLevel := cmdFlags.String("loglevel", "info", "minimum level of log messages to show (debug, info, warn, error)")
+	logFormat := cmdFlags.String("logformat", "text", "format of log messages (text, json)")
 
-	// Parse the CLI flags
+	// Parse the CLI flags, then fill in the rest from the environment and the config file
 	err := cmdFlags.Parse(os.Args[1:])
 	if err != nil {
 		os.Exit(1)
 	}
+	if *configFile == "" {
+		*configFile = os.Getenv(config.EnvPrefix + "CONFIG")
+	}
+	err = config.Apply(cmdFlags, *configFile, "config", "version")
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+
+	// Set up logging
+	if *debug {
+		*logLevel = "debug"
+	}
+	err = logging.Setup(*logLevel, *logFormat)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
 
 	// Read version info from embed files
 	err = utils.ReadFilesToStrings(&embedFiles, []utils.FileToString{
@@ -85,28 +168,74 @@ func main() {
 
 	// Start the server
 	c := server.Config{
-		TLSCert:     *tlsCert,
-		TLSKey:      *tlsKey,
-		SelfSigned:  *selfSigned,
-		Debug:       *debug,
-		Kubeconfig:  *kubeconfig,
-		BindHost:    *bindHost,
-		BindPort:    *bindPort,
-		DevMode:     *devMode,
-		NoToken:     *noToken,
-		AppVersion:  appVersion,
-		ChopRelease: chopRelease,
-		UIFiles:     &uiFiles,
-		EmbedFiles:  &embedFiles,
+		TLSCert:       *tlsCert,
+		TLSKey:        *tlsKey,
+		TLSClientCA:   *tlsClientCA,
+		TLSClientAuth: *tlsClientAuth,
+		SelfSigned:    *selfSigned,
+		CertDir:       *certDir,
+		Kubeconfig:    *kubeconfig,
+		KubeContext:   *kubeContext,
+		Clusters:      *clusters,
+		BindHost:      *bindHost,
+		BindPort:      *bindPort,
+		BasePath:      *basePath,
+		DevMode:       *devMode,
+		NoToken:       *noToken,
+		OIDC: server.OIDCConfig{
+			Issuer:        *oidcIssuer,
+			ClientID:      *oidcClientID,
+			ClientSecret:  *oidcClientSecret,
+			Scopes:        strings.Split(*oidcScopes, ","),
+			RedirectURL:   *oidcRedirectURL,
+			UsernameClaim: *oidcUsernameClaim,
+			GroupsClaim:   *oidcGroupsClaim,
+		},
+		PolicyFile:       *policyFile,
+		Impersonate:      *impersonate,
+		AuditLog:         *auditLog,
+		TokensFile:       *tokensFile,
+		TokensSecret:     *tokensSecret,
+		TokenReview:      *tokenReview,
+		OperatorVersion:  *operatorVersion,
+		DefaultNamespace: *defaultNamespace,
+		NoCache:          *noCache,
+		CacheSyncTime:    *cacheSyncTimeout,
+		NoMetrics:        *noMetrics,
+		MetricsAddr:      *metricsAddr,
+		SessionIdle:      *sessionIdle,
+		SessionMax:       *sessionMax,
+		AppVersion:       appVersion,
+		ChopRelease:      chopRelease,
+		UIFiles:          &uiFiles,
+		EmbedFiles:       &embedFiles,
 	}
 	err = c.RunServer()
 	if err != nil {
+		certs.RemoveGeneratedFiles()
 		log.Fatalf("Error: %s", err)
 	}
 	log.Printf("Server started.  Connect using: %s\n", c.URL)
 	if *openBrowser {
 		openWebBrowser(c.URL)
 	}
-	<-c.Context.Done()
-	log.Fatalf("Error: %s", c.ServerError)
+	go rotateTokens(&c, *tokenRotate)
+
+	// Run until the server fails or we are asked to stop
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGTERM, syscall.SIGINT)
+	select {
+	case <-c.Context.Done():
+		certs.RemoveGeneratedFiles()
+		log.Fatalf("Error: %s", c.ServerError)
+	case sig := <-stop:
+		log.Printf("Received %s, shutting down\n", sig)
+		signal.Stop(stop)
+	}
+	err = c.Shutdown(*shutdownTimeout)
+	certs.RemoveGeneratedFiles()
+	if err != nil {
+		log.Fatalf("Error: %s", err)
+	}
+	log.Printf("Server stopped\n")
 }
@@ -2,14 +2,58 @@ package api
 
 import (
 	"embed"
+	"errors"
+	"fmt"
+	"github.com/altinity/altinity-dashboard/internal/audit"
+	"github.com/altinity/altinity-dashboard/internal/auth"
+	"github.com/altinity/altinity-dashboard/internal/logging"
+	"github.com/altinity/altinity-dashboard/internal/metrics"
+	"github.com/altinity/altinity-dashboard/internal/utils"
 	"github.com/emicklei/go-restful/v3"
-	"log"
+	errors2 "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"log/slog"
+	"net/http"
 )
 
 type WebServiceInfo struct {
 	Version     string
 	ChopRelease string
-	Embed       *embed.FS
+	// OperatorVersion is the clickhouse-operator version deployed when none is requested, if not ChopRelease
+	OperatorVersion string
+	Embed           *embed.FS
+	Audit           *audit.Log
+	Tokens          *auth.Tokens
+	// Stopping is closed when the server starts shutting down, to end long-running streams
+	Stopping <-chan struct{}
+	// ClusterScoped causes the service to be served under clusterPath, acting on the cluster named in the URL
+	ClusterScoped bool
+}
+
+// clusterPath is the prefix of the API routes that act on a particular cluster
+const clusterPath = "/api/v1/clusters/{cluster}"
+
+// newWebService creates a web service for the API routes under /api/v1 + path.  If wsi is cluster scoped,
+// the routes are instead served under clusterPath + path.
+func newWebService(wsi *WebServiceInfo, path string) *restful.WebService {
+	ws := new(restful.WebService)
+	if !wsi.ClusterScoped {
+		return ws.Path("/api/v1" + path)
+	}
+	return ws.
+		Path(clusterPath + path).
+		Param(ws.PathParameter("cluster", "name of the cluster").DataType("string")).
+		Filter(requireCluster)
+}
+
+// requireCluster is a filter that rejects requests naming a cluster that is not configured
+func requireCluster(request *restful.Request, response *restful.Response, chain *restful.FilterChain) {
+	if !utils.HasCluster(request.PathParameter("cluster")) {
+		webError(request, response, http.StatusNotFound,
+			fmt.Errorf("%w: %s", utils.ErrUnknownCluster, request.PathParameter("cluster")))
+		return
+	}
+	chain.ProcessFilter(request, response)
 }
 
 type WebService interface {
@@ -17,11 +61,104 @@ type WebService interface {
 	WebService(*WebServiceInfo) (*restful.WebService, error)
 }
 
-var ErrorsToConsole bool
-
-func webError(response *restful.Response, status int, err error) {
-	if ErrorsToConsole {
-		log.Printf("%s\n", err)
+func webError(request *restful.Request, response *restful.Response, status int, err error) {
+	if status == http.StatusInternalServerError {
+		status = statusForError(err)
+	}
+	requestID := recordError(request, status, err)
+	if requestID != "" {
+		_ = response.WriteErrorString(status, fmt.Sprintf("%s (request ID %s)", err, requestID))
+		return
 	}
 	_ = response.WriteError(status, err)
 }
+
+// recordError counts and logs an error response to a request, and returns the request's ID
+func recordError(request *restful.Request, status int, err error) string {
+	errType := errorType(err)
+	metrics.Errors.WithLabelValues(errType).Inc()
+	level := slog.LevelDebug
+	if status >= http.StatusInternalServerError {
+		level = slog.LevelError
+	}
+	ctx := request.Request.Context()
+	slog.LogAttrs(ctx, level, "API error",
+		slog.Int("status", status),
+		slog.String("type", errType),
+		slog.String("error", err.Error()),
+	)
+	return logging.RequestID(ctx)
+}
+
+// namedErrors are the errors counted under their own names in the error metrics
+var namedErrors = map[string]error{
+	"ErrOperatorNotDeployed": utils.ErrOperatorNotDeployed,
+	"ErrStillHaveCHIs":       ErrStillHaveCHIs,
+	"ErrNoNamespace":         utils.ErrNoNamespace,
+	"ErrNamespaceConflict":   utils.ErrNamespaceConflict,
+	"ErrNamespaceRequired":   ErrNamespaceRequired,
+	"ErrNameRequired":        ErrNameRequired,
+	"ErrYAMLMustBeCHI":       ErrYAMLMustBeCHI,
+	"ErrForbidden":           ErrForbidden,
+	"ErrNoUser":              ErrNoUser,
+	"ErrTokenNameInvalid":    auth.ErrTokenNameInvalid,
+	"ErrTokenScopeInvalid":   auth.ErrTokenScopeInvalid,
+	"ErrTokenExists":         auth.ErrTokenExists,
+	"ErrTokenNotFound":       auth.ErrTokenNotFound,
+	"ErrUnknownRole":         auth.ErrUnknownRole,
+	"ErrUnknownCluster":      utils.ErrUnknownCluster,
+	"ErrUnknownContext":      utils.ErrUnknownContext,
+	"ErrUnknownWatchKind":    ErrUnknownWatchKind,
+	"ErrDryRunInvalid":       ErrDryRunInvalid,
+	"ErrOriginalYAMLInvalid": ErrOriginalYAMLInvalid,
+}
+
+// statusForError returns the status to respond with for an unexpected error.  Errors from the API server that
+// are the client's fault, such as an RBAC denial under -impersonate, keep their own status.
+func statusForError(err error) int {
+	var apiStatus errors2.APIStatus
+	if errors.As(err, &apiStatus) {
+		code := int(apiStatus.Status().Code)
+		if code >= http.StatusBadRequest && code < http.StatusInternalServerError {
+			return code
+		}
+	}
+	return http.StatusInternalServerError
+}
+
+// errorType classifies an error for the error metrics
+func errorType(err error) string {
+	for name, e := range namedErrors {
+		if errors.Is(err, e) {
+			return name
+		}
+	}
+	if reason := errors2.ReasonForError(err); reason != metav1.StatusReasonUnknown {
+		return "Kubernetes" + string(reason)
+	}
+	return "Other"
+}
+
+// Impersonate causes Kubernetes requests to be made as the logged-in user, rather than as the dashboard
+var Impersonate bool
+
+var ErrNoUser = errors.New("no authenticated user to impersonate")
+
+// getK8s gets a reference to the Kubernetes instance to use for a request, for the cluster named in its URL
+// or the default cluster.  The caller must call ReleaseK8s.
+func getK8s(request *restful.Request) (*utils.K8s, error) {
+	return getClusterK8s(request, request.PathParameter("cluster"))
+}
+
+// getClusterK8s gets a reference to the Kubernetes instance to use for a request to the named cluster.
+// The caller must call ReleaseK8s.
+func getClusterK8s(request *restful.Request, cluster string) (*utils.K8s, error) {
+	if !Impersonate {
+		return utils.GetClusterK8s(cluster)
+	}
+	user := auth.UserFromContext(request.Request.Context())
+	if user == nil {
+		return nil, ErrNoUser
+	}
+	return utils.GetClusterK8sAs(cluster, user.Name, user.Groups)
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"errors"
 	"github.com/altinity/altinity-dashboard/internal/utils"
+	chopv1 "github.com/altinity/clickhouse-operator/pkg/apis/clickhouse.altinity.com/v1"
+	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	errors2 "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -32,22 +34,17 @@ func getContainersFromPod(pod *corev1.Pod) []Container {
 	return list
 }
 
-func getPVCsFromPod(pod *corev1.Pod) ([]PersistentVolumeClaim, error) {
-	k := utils.GetK8s()
-	defer func() { k.ReleaseK8s() }()
-
+func getPVCsFromPod(k *utils.K8s, pod *corev1.Pod) ([]PersistentVolumeClaim, error) {
 	list := make([]PersistentVolumeClaim, 0)
 	for _, vol := range pod.Spec.Volumes {
 		if vol.PersistentVolumeClaim != nil {
-			pvc, err := k.Clientset.CoreV1().PersistentVolumeClaims(pod.Namespace).Get(context.TODO(),
-				vol.PersistentVolumeClaim.ClaimName, metav1.GetOptions{})
+			pvc, err := getK8sPVC(k, pod.Namespace, vol.PersistentVolumeClaim.ClaimName)
 			if err != nil {
 				return nil, err
 			}
 			var pv *corev1.PersistentVolume
 			if pvc.Spec.VolumeName != "" {
-				pv, err = k.Clientset.CoreV1().PersistentVolumes().Get(context.TODO(),
-					pvc.Spec.VolumeName, metav1.GetOptions{})
+				pv, err = getK8sPV(k, pvc.Spec.VolumeName)
 				if err != nil {
 					pv = nil
 					var sv *errors2.StatusError
@@ -97,13 +94,34 @@ func getPVCsFromPod(pod *corev1.Pod) ([]PersistentVolumeClaim, error) {
 	return list, nil
 }
 
-func getK8sPodsFromLabelSelector(namespace string, selector *metav1.LabelSelector) (*corev1.PodList, error) {
+// getK8sPVC gets a PVC from the cache, or from the API server if it is not cached
+func getK8sPVC(k *utils.K8s, namespace string, name string) (*corev1.PersistentVolumeClaim, error) {
+	if obj, ok := k.Cache().Get(utils.ResourcePVCs, namespace, name); ok {
+		return obj.(*corev1.PersistentVolumeClaim), nil
+	}
+	return k.Clientset.CoreV1().PersistentVolumeClaims(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+}
+
+// getK8sPV gets a PV from the cache, or from the API server if it is not cached
+func getK8sPV(k *utils.K8s, name string) (*corev1.PersistentVolume, error) {
+	if obj, ok := k.Cache().Get(utils.ResourcePVs, "", name); ok {
+		return obj.(*corev1.PersistentVolume), nil
+	}
+	return k.Clientset.CoreV1().PersistentVolumes().Get(context.TODO(), name, metav1.GetOptions{})
+}
+
+func getK8sPodsFromLabelSelector(k *utils.K8s, namespace string, selector *metav1.LabelSelector) (*corev1.PodList, error) {
 	ls, err := metav1.LabelSelectorAsMap(selector)
 	if err != nil {
 		return nil, err
 	}
-	k := utils.GetK8s()
-	defer func() { k.ReleaseK8s() }()
+	if objs, ok := k.Cache().List(utils.ResourcePods, namespace, labels.SelectorFromSet(ls)); ok {
+		pods := &corev1.PodList{Items: make([]corev1.Pod, 0, len(objs))}
+		for _, obj := range objs {
+			pods.Items = append(pods.Items, *obj.(*corev1.Pod))
+		}
+		return pods, nil
+	}
 	pods, err := k.Clientset.CoreV1().Pods(namespace).List(context.TODO(),
 		metav1.ListOptions{
 			LabelSelector: labels.SelectorFromSet(ls).String(),
@@ -115,13 +133,18 @@ func getK8sPodsFromLabelSelector(namespace string, selector *metav1.LabelSelecto
 	return pods, nil
 }
 
-func getK8sServicesFromLabelSelector(namespace string, selector *metav1.LabelSelector) (*corev1.ServiceList, error) {
+func getK8sServicesFromLabelSelector(k *utils.K8s, namespace string, selector *metav1.LabelSelector) (*corev1.ServiceList, error) {
 	ls, err := metav1.LabelSelectorAsMap(selector)
 	if err != nil {
 		return nil, err
 	}
-	k := utils.GetK8s()
-	defer func() { k.ReleaseK8s() }()
+	if objs, ok := k.Cache().List(utils.ResourceServices, namespace, labels.SelectorFromSet(ls)); ok {
+		services := &corev1.ServiceList{Items: make([]corev1.Service, 0, len(objs))}
+		for _, obj := range objs {
+			services.Items = append(services.Items, *obj.(*corev1.Service))
+		}
+		return services, nil
+	}
 	var services *corev1.ServiceList
 	services, err = k.Clientset.CoreV1().Services(namespace).List(context.TODO(),
 		metav1.ListOptions{
@@ -134,8 +157,59 @@ func getK8sServicesFromLabelSelector(namespace string, selector *metav1.LabelSel
 	return services, nil
 }
 
-func getPodFromK8sPod(pod *corev1.Pod) (*Pod, error) {
-	pvcs, err := getPVCsFromPod(pod)
+// getK8sOperatorDeployments gets the clickhouse-operator deployments in a namespace, or in all namespaces if
+// namespace is empty
+func getK8sOperatorDeployments(k *utils.K8s, namespace string) (*appsv1.DeploymentList, error) {
+	selector := labels.SelectorFromSet(labels.Set{"app": "clickhouse-operator"})
+	if objs, ok := k.Cache().List(utils.ResourceDeployments, namespace, selector); ok {
+		deployments := &appsv1.DeploymentList{Items: make([]appsv1.Deployment, 0, len(objs))}
+		for _, obj := range objs {
+			deployments.Items = append(deployments.Items, *obj.(*appsv1.Deployment))
+		}
+		return deployments, nil
+	}
+	return k.Clientset.AppsV1().Deployments(namespace).List(context.TODO(), metav1.ListOptions{
+		LabelSelector: selector.String(),
+	})
+}
+
+// getK8sNamespaces gets all namespaces
+func getK8sNamespaces(k *utils.K8s) (*corev1.NamespaceList, error) {
+	if objs, ok := k.Cache().List(utils.ResourceNamespaces, "", nil); ok {
+		namespaces := &corev1.NamespaceList{Items: make([]corev1.Namespace, 0, len(objs))}
+		for _, obj := range objs {
+			namespaces.Items = append(namespaces.Items, *obj.(*corev1.Namespace))
+		}
+		return namespaces, nil
+	}
+	return k.Clientset.CoreV1().Namespaces().List(context.TODO(), metav1.ListOptions{})
+}
+
+// getK8sCHIs gets the CHIs in a namespace, or in all namespaces if namespace is empty, optionally limited to
+// those with the given name
+func getK8sCHIs(k *utils.K8s, namespace string, name string) (*chopv1.ClickHouseInstallationList, error) {
+	if objs, ok := k.Cache().List(utils.ResourceCHIs, namespace, nil); ok {
+		chis := &chopv1.ClickHouseInstallationList{Items: make([]chopv1.ClickHouseInstallation, 0, len(objs))}
+		for _, obj := range objs {
+			chi := obj.(*chopv1.ClickHouseInstallation)
+			if name == "" || chi.Name == name {
+				chis.Items = append(chis.Items, *chi)
+			}
+		}
+		return chis, nil
+	}
+	var fieldSelector string
+	if name != "" {
+		fieldSelector = "metadata.name=" + name
+	}
+	return k.ChopClientset.ClickhouseV1().ClickHouseInstallations(namespace).List(
+		context.TODO(), metav1.ListOptions{
+			FieldSelector: fieldSelector,
+		})
+}
+
+func getPodFromK8sPod(k *utils.K8s, pod *corev1.Pod) (*Pod, error) {
+	pvcs, err := getPVCsFromPod(k, pod)
 	if err != nil {
 		return nil, err
 	}
@@ -148,11 +222,11 @@ func getPodFromK8sPod(pod *corev1.Pod) (*Pod, error) {
 	}, nil
 }
 
-func getPodsFromK8sPods(pods *corev1.PodList) ([]*Pod, error) {
+func getPodsFromK8sPods(k *utils.K8s, pods *corev1.PodList) ([]*Pod, error) {
 	list := make([]*Pod, 0, len(pods.Items))
 	for i := range pods.Items {
 		k8pod := pods.Items[i]
-		pod, err := getPodFromK8sPod(&k8pod)
+		pod, err := getPodFromK8sPod(k, &k8pod)
 		if err != nil {
 			return nil, err
 		}
@@ -1,5 +1,7 @@
 package api
 
+import "github.com/altinity/altinity-dashboard/internal/utils"
+
 type Namespace struct {
 	Name string `json:"name" description:"name of the namespace"`
 }
@@ -79,10 +81,82 @@ type CHClusterPod struct {
 }
 
 type Dashboard struct {
-	KubeCluster        string `json:"kube_cluster" description:"kubernetes cluster name"`
-	KubeVersion        string `json:"kube_version" description:"kubernetes cluster version"`
-	ChopCount          int    `json:"chop_count" description:"number of clickhouse-operators deployed"`
-	ChopCountAvailable int    `json:"chop_count_available" description:"number of clickhouse-operators available"`
-	ChiCount           int    `json:"chi_count" description:"number of ClickHouse Installations deployed"`
-	ChiCountComplete   int    `json:"chi_count_complete" description:"number of ClickHouse Installations completed"`
+	KubeCluster        string             `json:"kube_cluster" description:"kubernetes cluster name of the default cluster"`
+	KubeVersion        string             `json:"kube_version" description:"kubernetes cluster version of the default cluster"`
+	ChopCount          int                `json:"chop_count" description:"number of clickhouse-operators deployed, across all clusters"`
+	ChopCountAvailable int                `json:"chop_count_available" description:"number of clickhouse-operators available, across all clusters"`
+	ChiCount           int                `json:"chi_count" description:"number of ClickHouse Installations deployed, across all clusters"`
+	ChiCountComplete   int                `json:"chi_count_complete" description:"number of ClickHouse Installations completed, across all clusters"`
+	AuthStatus         string             `json:"auth_status" description:"whether the default cluster accepts the dashboard's credentials (ok, unauthorized, forbidden or unknown)"`
+	Clusters           []DashboardCluster `json:"clusters" description:"dashboard information for each cluster"`
+}
+
+type DashboardCluster struct {
+	Name               string                  `json:"name" description:"name of the cluster in the dashboard"`
+	KubeCluster        string                  `json:"kube_cluster" description:"kubernetes cluster name"`
+	KubeVersion        string                  `json:"kube_version" description:"kubernetes cluster version"`
+	ChopCount          int                     `json:"chop_count" description:"number of clickhouse-operators deployed"`
+	ChopCountAvailable int                     `json:"chop_count_available" description:"number of clickhouse-operators available"`
+	ChiCount           int                     `json:"chi_count" description:"number of ClickHouse Installations deployed"`
+	ChiCountComplete   int                     `json:"chi_count_complete" description:"number of ClickHouse Installations completed"`
+	AuthStatus         string                  `json:"auth_status" description:"whether the cluster accepts the dashboard's credentials (ok, unauthorized, forbidden or unknown)"`
+	Kubeconfig         *utils.KubeconfigStatus `json:"kubeconfig,omitempty" description:"state of the kubeconfig, unless running with the in-cluster configuration"`
+	Cache              []utils.CacheStatus     `json:"cache,omitempty" description:"state of the cache of each resource, if reads are cached"`
+	Error              string                  `json:"error,omitempty" description:"error connecting to the cluster, if any"`
+}
+
+type KubeContext struct {
+	Name      string `json:"name" description:"name of the context"`
+	Cluster   string `json:"cluster" description:"name of the kubeconfig cluster the context uses"`
+	Server    string `json:"server" description:"kubernetes API server URL"`
+	Namespace string `json:"namespace" description:"default namespace of the context"`
+	User      string `json:"user" description:"name of the kubeconfig user the context uses"`
+	Active    bool   `json:"active" description:"whether the dashboard is using this context"`
+}
+
+type Cluster struct {
+	Name        string `json:"name" description:"name of the cluster in the dashboard"`
+	KubeCluster string `json:"kube_cluster" description:"kubernetes API server URL"`
+	Default     bool   `json:"default" description:"whether this is the cluster used when none is named"`
+}
+
+type DryRunResult struct {
+	Valid   bool                     `json:"valid" description:"whether the API server accepted the change"`
+	Objects []map[string]interface{} `json:"objects,omitempty" description:"the objects as they would be stored, if the change was accepted"`
+	Error   string                   `json:"error,omitempty" description:"why the API server rejected the change, if it did"`
+	Causes  []DryRunCause            `json:"causes,omitempty" description:"the individual validation or admission failures, if known"`
+}
+
+type DryRunCause struct {
+	Field   string `json:"field,omitempty" description:"field the failure is about, if any"`
+	Reason  string `json:"reason,omitempty" description:"machine-readable reason for the failure"`
+	Message string `json:"message" description:"description of the failure"`
+}
+
+type ChiDiff struct {
+	Changes         []ChiChange `json:"changes" description:"changes to the CHI's spec"`
+	RestartRequired bool        `json:"restart_required" description:"whether any of the changes cause pods to be restarted"`
+	Unified         string      `json:"unified" description:"unified diff of the live and submitted specs, as YAML"`
+}
+
+type ChiChange struct {
+	Path    string      `json:"path" description:"path of the changed field, with list items identified by name where they have one, as in spec.templates.podTemplates[name=default].spec.containers[name=clickhouse].image"`
+	Type    string      `json:"type" description:"type of change (added, removed or modified)"`
+	Old     interface{} `json:"old" description:"live value of the field, if any"`
+	New     interface{} `json:"new" description:"submitted value of the field, if any"`
+	Restart bool        `json:"restart" description:"whether the change causes pods to be restarted (pod templates, images and volume claim templates), rather than only changing the configuration"`
+}
+
+type ChiConflict struct {
+	Error       string             `json:"error" description:"description of the conflict"`
+	CurrentYAML string             `json:"current_yaml" description:"YAML of the CHI as it is now, to start a new edit from"`
+	Conflicts   []ChiConflictField `json:"conflicts,omitempty" description:"fields changed both in the submitted YAML and since original_yaml; omitted if original_yaml was not given"`
+	Mergeable   bool               `json:"mergeable,omitempty" description:"whether none of the same fields were changed, so the update would succeed with merge"`
+}
+
+type ChiConflictField struct {
+	Path     string      `json:"path" description:"path of the field, as in the CHI diff"`
+	Original interface{} `json:"original" description:"value in original_yaml"`
+	Yours    interface{} `json:"yours" description:"submitted value"`
+	Current  interface{} `json:"current" description:"value in the CHI now"`
 }
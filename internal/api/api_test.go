@@ -0,0 +1,72 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/altinity/altinity-dashboard/internal/logging"
+	"github.com/altinity/altinity-dashboard/internal/utils"
+	"github.com/emicklei/go-restful/v3"
+	errors2 "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestStatusForError(t *testing.T) {
+	chis := schema.GroupResource{Group: "clickhouse.altinity.com", Resource: "clickhouseinstallations"}
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"forbidden", errors2.NewForbidden(chis, "test", errors.New("denied")), http.StatusForbidden},
+		{"wrapped", fmt.Errorf("updating: %w", errors2.NewNotFound(chis, "test")), http.StatusNotFound},
+		{"conflict", errors2.NewConflict(chis, "test", errors.New("changed")), http.StatusConflict},
+		{"server error", errors2.NewInternalError(errors.New("etcd")), http.StatusInternalServerError},
+		{"timeout", errors2.NewServerTimeout(chis, "get", 1), http.StatusInternalServerError},
+		{"other", utils.ErrOperatorNotDeployed, http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := statusForError(tt.err); got != tt.want {
+				t.Errorf("got %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+// requestInfoRecorder records the request info in the context of each message logged
+type requestInfoRecorder struct {
+	slog.Handler
+	logged []*logging.RequestInfo
+}
+
+func (h *requestInfoRecorder) Enabled(context.Context, slog.Level) bool { return true }
+
+func (h *requestInfoRecorder) Handle(ctx context.Context, _ slog.Record) error {
+	h.logged = append(h.logged, logging.RequestInfoFromContext(ctx))
+	return nil
+}
+
+func TestWebErrorLogsRequest(t *testing.T) {
+	rec := &requestInfoRecorder{}
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(rec))
+
+	ri := &logging.RequestInfo{ID: "abc123", User: "alice"}
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/chis", nil)
+	r = r.WithContext(logging.WithRequestInfo(r.Context(), ri))
+	w := httptest.NewRecorder()
+	webError(restful.NewRequest(r), restful.NewResponse(w), http.StatusInternalServerError, errors.New("failed"))
+
+	if len(rec.logged) != 1 || rec.logged[0] != ri {
+		t.Errorf("logged with request info %v, want %v", rec.logged, ri)
+	}
+	if w.Code != http.StatusInternalServerError || !strings.Contains(w.Body.String(), "request ID abc123") {
+		t.Errorf("got %d %q", w.Code, w.Body.String())
+	}
+}
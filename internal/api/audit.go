@@ -0,0 +1,142 @@
+package api
+
+import (
+	"github.com/altinity/altinity-dashboard/internal/audit"
+	"github.com/altinity/altinity-dashboard/internal/auth"
+	"github.com/altinity/altinity-dashboard/internal/logging"
+	"github.com/emicklei/go-restful/v3"
+	"net"
+	"net/http"
+	"time"
+)
+
+// AuditResource is the REST layer to the audit log
+type AuditResource struct {
+	log *audit.Log
+}
+
+// Name returns the name of the web service
+func (a *AuditResource) Name() string {
+	return "Audit"
+}
+
+// WebService creates a new service that can handle REST requests
+func (a *AuditResource) WebService(wsi *WebServiceInfo) (*restful.WebService, error) {
+	a.log = wsi.Audit
+
+	ws := new(restful.WebService)
+	ws.
+		Path("/api/v1/audit").
+		Produces(restful.MIME_JSON).
+		Filter(requireRole(auth.RoleAdmin))
+
+	ws.Route(ws.GET("").To(a.getAudit).
+		Doc("get audit events").
+		Param(ws.QueryParameter("since", "only return events at or after this time (RFC 3339)").DataType("string")).
+		Param(ws.QueryParameter("until", "only return events before this time (RFC 3339)").DataType("string")).
+		Param(ws.QueryParameter("user", "only return events for this user").DataType("string")).
+		Param(ws.QueryParameter("cluster", "only return events for this cluster").DataType("string")).
+		Writes([]audit.Event{}).
+		Returns(200, "OK", []audit.Event{}))
+
+	return ws, nil
+}
+
+func (a *AuditResource) getAudit(request *restful.Request, response *restful.Response) {
+	q := audit.Query{
+		User:    request.QueryParameter("user"),
+		Cluster: request.QueryParameter("cluster"),
+	}
+	var err error
+	for param, dest := range map[string]*time.Time{
+		"since": &q.Since,
+		"until": &q.Until,
+	} {
+		if v := request.QueryParameter(param); v != "" {
+			*dest, err = time.Parse(time.RFC3339, v)
+			if err != nil {
+				webError(request, response, http.StatusBadRequest, err)
+				return
+			}
+		}
+	}
+	events, err := a.log.Query(&q)
+	if err != nil {
+		webError(request, response, http.StatusInternalServerError, err)
+		return
+	}
+	_ = response.WriteEntity(events)
+}
+
+const auditTargetName = "audit.name"
+const auditBeforeYAML = "audit.before"
+const auditAfterYAML = "audit.after"
+const auditSkip = "audit.skip"
+
+// setAuditTarget records the name of the object a request acts on, when it isn't a path parameter
+func setAuditTarget(request *restful.Request, name string) {
+	request.SetAttribute(auditTargetName, name)
+}
+
+// setAuditYAML records the state of the object a request acts on, before and/or after the request
+func setAuditYAML(request *restful.Request, before string, after string) {
+	if before != "" {
+		request.SetAttribute(auditBeforeYAML, before)
+	}
+	if after != "" {
+		request.SetAttribute(auditAfterYAML, after)
+	}
+}
+
+// skipAudit marks a request that does not change anything, despite its method, so it is not recorded
+func skipAudit(request *restful.Request) {
+	request.SetAttribute(auditSkip, true)
+}
+
+// auditMutations returns a filter that records every non-GET request in the audit log
+func auditMutations(auditLog *audit.Log) restful.FilterFunction {
+	return func(request *restful.Request, response *restful.Response, chain *restful.FilterChain) {
+		chain.ProcessFilter(request, response)
+		r := request.Request
+		if auditLog == nil || r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions {
+			return
+		}
+		if skip, _ := request.Attribute(auditSkip).(bool); skip {
+			return
+		}
+
+		e := audit.Event{
+			Time:      time.Now(),
+			Method:    r.Method,
+			Route:     request.SelectedRoutePath(),
+			Path:      r.URL.Path,
+			Cluster:   request.PathParameter("cluster"),
+			Namespace: request.PathParameter("namespace"),
+			Name:      request.PathParameter("name"),
+			Status:    response.StatusCode(),
+			RequestID: logging.RequestID(r.Context()),
+		}
+		e.DryRun, _ = isDryRun(request)
+		if user := auth.UserFromContext(r.Context()); user != nil {
+			e.User = user.Name
+		}
+		if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+			e.SourceIP = host
+		} else {
+			e.SourceIP = r.RemoteAddr
+		}
+		if name, ok := request.Attribute(auditTargetName).(string); ok {
+			e.Name = name
+		}
+		if y, ok := request.Attribute(auditBeforeYAML).(string); ok {
+			e.BeforeDigest = audit.Digest(y)
+		}
+		if y, ok := request.Attribute(auditAfterYAML).(string); ok {
+			e.AfterDigest = audit.Digest(y)
+		}
+		if err := response.Error(); err != nil {
+			e.Error = err.Error()
+		}
+		auditLog.Record(r.Context(), &e)
+	}
+}
@@ -0,0 +1,22 @@
+package api
+
+import (
+	"errors"
+	"github.com/altinity/altinity-dashboard/internal/auth"
+	"github.com/emicklei/go-restful/v3"
+	"net/http"
+)
+
+var ErrForbidden = errors.New("you do not have permission to perform this action")
+
+// requireRole returns a filter that only lets through callers holding at least the given role
+func requireRole(role auth.Role) restful.FilterFunction {
+	return func(request *restful.Request, response *restful.Response, chain *restful.FilterChain) {
+		user := auth.UserFromContext(request.Request.Context())
+		if user == nil || user.Role < role {
+			webError(request, response, http.StatusForbidden, ErrForbidden)
+			return
+		}
+		chain.ProcessFilter(request, response)
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"github.com/altinity/altinity-dashboard/internal/auth"
+	"github.com/altinity/altinity-dashboard/internal/metrics"
 	"github.com/altinity/altinity-dashboard/internal/utils"
 	chopv1 "github.com/altinity/clickhouse-operator/pkg/apis/clickhouse.altinity.com/v1"
 	"github.com/emicklei/go-restful/v3"
@@ -11,7 +13,7 @@ import (
 	errors2 "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
-	"log"
+	"log/slog"
 	"net/http"
 	"sigs.k8s.io/yaml"
 )
@@ -23,6 +25,9 @@ type ChiResource struct {
 // ChiPutParams is the object for parameters to a CHI PUT request
 type ChiPutParams struct {
 	YAML string `json:"yaml" description:"YAML of the CHI custom resource"`
+	// OriginalYAML and Merge are only used when updating
+	OriginalYAML string `json:"original_yaml,omitempty" description:"YAML the edit started from, as given in resource_yaml, used to tell which fields conflict if the CHI has changed since"`
+	Merge        bool   `json:"merge,omitempty" description:"if the CHI has changed since original_yaml, save the edits anyway when they don't overlap with those changes"`
 }
 
 // Name returns the name of the web service
@@ -31,12 +36,13 @@ func (c *ChiResource) Name() string {
 }
 
 // WebService creates a new service that can handle REST requests
-func (c *ChiResource) WebService(_ *WebServiceInfo) (*restful.WebService, error) {
-	ws := new(restful.WebService)
+func (c *ChiResource) WebService(wsi *WebServiceInfo) (*restful.WebService, error) {
+	ws := newWebService(wsi, "/chis")
 	ws.
-		Path("/api/v1/chis").
 		Consumes(restful.MIME_JSON).
-		Produces(restful.MIME_JSON)
+		Produces(restful.MIME_JSON).
+		Filter(requireRole(auth.RoleViewer)).
+		Filter(auditMutations(wsi.Audit))
 
 	ws.Route(ws.GET("").To(c.getCHIs).
 		Doc("get all ClickHouse Installations").
@@ -57,19 +63,35 @@ func (c *ChiResource) WebService(_ *WebServiceInfo) (*restful.WebService, error)
 		Returns(200, "OK", []Chi{}))
 
 	ws.Route(ws.POST("/{namespace}").To(c.handlePostCHI).
+		Filter(requireRole(auth.RoleOperator)).
 		Doc("deploy a new ClickHouse Installation from YAML").
 		Param(ws.PathParameter("namespace", "namespace to deploy to").DataType("string")).
+		Param(dryRunParam(ws)).
 		Reads(ChiPutParams{}).
-		Returns(200, "OK", nil))
+		Returns(200, "OK", nil).
+		Returns(422, "Rejected by the API server (dry run)", DryRunResult{}))
 
 	ws.Route(ws.PATCH("/{namespace}/{name}").To(c.handlePatchCHI).
+		Filter(requireRole(auth.RoleOperator)).
 		Doc("update an existing ClickHouse Installation from YAML").
 		Param(ws.PathParameter("namespace", "namespace the CHI is in").DataType("string")).
 		Param(ws.PathParameter("name", "name of the CHI to update").DataType("string")).
+		Param(dryRunParam(ws)).
 		Reads(ChiPutParams{}).
-		Returns(200, "OK", nil))
+		Returns(200, "OK", nil).
+		Returns(409, "Changed since it was loaded", ChiConflict{}).
+		Returns(422, "Rejected by the API server (dry run)", DryRunResult{}))
+
+	ws.Route(ws.POST("/{namespace}/{name}/diff").To(c.handleDiffCHI).
+		Doc("compare YAML for an existing ClickHouse Installation with the live object, without changing it").
+		Param(ws.PathParameter("namespace", "namespace the CHI is in").DataType("string")).
+		Param(ws.PathParameter("name", "name of the CHI to compare with").DataType("string")).
+		Reads(ChiPutParams{}).
+		Writes(ChiDiff{}).
+		Returns(200, "OK", ChiDiff{}))
 
 	ws.Route(ws.DELETE("/{namespace}/{name}").To(c.handleDeleteCHI).
+		Filter(requireRole(auth.RoleOperator)).
 		Doc("delete a ClickHouse installation").
 		Param(ws.PathParameter("namespace", "namespace to delete from").DataType("string")).
 		Param(ws.PathParameter("name", "name of the CHI to delete").DataType("string")).
@@ -88,18 +110,15 @@ func (c *ChiResource) getCHIs(request *restful.Request, response *restful.Respon
 		name = ""
 	}
 
-	k := utils.GetK8s()
-	defer func() { k.ReleaseK8s() }()
-	var fieldSelector string
-	if name != "" {
-		fieldSelector = "metadata.name=" + name
+	k, err := getK8s(request)
+	if err != nil {
+		webError(request, response, http.StatusInternalServerError, err)
+		return
 	}
+	defer func() { k.ReleaseK8s() }()
 
 	getCHIs := func() (*chopv1.ClickHouseInstallationList, error) {
-		chis, err := k.ChopClientset.ClickhouseV1().ClickHouseInstallations(namespace).List(
-			context.TODO(), metav1.ListOptions{
-				FieldSelector: fieldSelector,
-			})
+		chis, err := getK8sCHIs(k, namespace, name)
 		if err != nil {
 			var se *errors2.StatusError
 			if errors.As(err, &se) {
@@ -116,115 +135,155 @@ func (c *ChiResource) getCHIs(request *restful.Request, response *restful.Respon
 	if errors.Is(err, utils.ErrOperatorNotDeployed) {
 		// Before returning ErrOperatorNotDeployed, try reinitializing the k8s client, which may
 		// be holding old information in its cache.  (For example, it may not know about a CRD.)
-		k.ReleaseK8s()
-		err = k.Reinit()
-		k = utils.GetK8s()
+		err = k.ReinitHeld()
 		if err != nil {
-			log.Printf("Error reinitializing the Kubernetes client: %s", err)
-			webError(response, http.StatusInternalServerError, err)
+			slog.ErrorContext(request.Request.Context(), "Error reinitializing the Kubernetes client", slog.Any("err", err))
+			webError(request, response, http.StatusInternalServerError, err)
 			return
 		}
 		chis, err = getCHIs()
 	}
 	if err != nil {
-		webError(response, http.StatusInternalServerError, err)
+		webError(request, response, http.StatusInternalServerError, err)
 		return
 	}
+	if namespace == "" && name == "" {
+		metrics.CHIs.WithLabelValues(k.Name).Set(float64(len(chis.Items)))
+	}
 
 	list := make([]Chi, 0, len(chis.Items))
-	for _, chi := range chis.Items {
-		chClusterPods := make([]CHClusterPod, 0)
-		errs := chi.WalkClusters(func(cluster *chopv1.ChiCluster) error {
-			sel := &metav1.LabelSelector{
-				MatchLabels: map[string]string{
-					"clickhouse.altinity.com/chi":     chi.Name,
-					"clickhouse.altinity.com/cluster": cluster.Name,
-				},
-				MatchExpressions: nil,
-			}
-			var kubePods *v1.PodList
-			kubePods, err = getK8sPodsFromLabelSelector(chi.Namespace, sel)
-			if err == nil {
-				var pods []*Pod
-				pods, err = getPodsFromK8sPods(kubePods)
-				if err != nil {
-					return err
-				}
-				for _, pod := range pods {
-					chClusterPod := CHClusterPod{
-						Pod:         *pod,
-						ClusterName: cluster.Name,
-					}
-					chClusterPods = append(chClusterPods, chClusterPod)
-				}
-			}
-			return nil
-		})
-		for _, werr := range errs {
-			if werr != nil {
-				webError(response, http.StatusInternalServerError, werr)
-				return
-			}
+	for i := range chis.Items {
+		var chi *Chi
+		chi, err = getChiFromK8sCHI(k, &chis.Items[i])
+		if err != nil {
+			webError(request, response, http.StatusInternalServerError, err)
+			return
 		}
-		var externalURL string
-		var services *v1.ServiceList
-		services, err = getK8sServicesFromLabelSelector(namespace, &metav1.LabelSelector{
+		list = append(list, *chi)
+	}
+	_ = response.WriteEntity(list)
+}
+
+// getChiFromK8sCHI converts a CHI resource to its API model, looking up its pods and services
+func getChiFromK8sCHI(k *utils.K8s, chi *chopv1.ClickHouseInstallation) (*Chi, error) {
+	var err error
+	chClusterPods := make([]CHClusterPod, 0)
+	errs := chi.WalkClusters(func(cluster *chopv1.ChiCluster) error {
+		sel := &metav1.LabelSelector{
 			MatchLabels: map[string]string{
-				"clickhouse.altinity.com/chi": chi.Name,
+				"clickhouse.altinity.com/chi":     chi.Name,
+				"clickhouse.altinity.com/cluster": cluster.Name,
 			},
-		})
+			MatchExpressions: nil,
+		}
+		var kubePods *v1.PodList
+		kubePods, err = getK8sPodsFromLabelSelector(k, chi.Namespace, sel)
 		if err == nil {
-			for _, svc := range services.Items {
-				if _, ok := svc.Labels["clickhouse.altinity.com/cluster"]; !ok && svc.Spec.Type == "LoadBalancer" {
-					for _, ing := range svc.Status.LoadBalancer.Ingress {
-						externalHost := ""
-						if ing.Hostname != "" {
-							externalHost = ing.Hostname
-						} else if ing.IP != "" {
-							externalHost = ing.IP
-						}
-						if externalHost == "" {
-							continue
-						}
-						for _, port := range svc.Spec.Ports {
-							if port.Name == "http" {
-								externalURL = fmt.Sprintf("http://%s:%d", externalHost, port.Port)
-								break
-							}
-						}
-						if externalURL != "" {
+			var pods []*Pod
+			pods, err = getPodsFromK8sPods(k, kubePods)
+			if err != nil {
+				return err
+			}
+			for _, pod := range pods {
+				chClusterPod := CHClusterPod{
+					Pod:         *pod,
+					ClusterName: cluster.Name,
+				}
+				chClusterPods = append(chClusterPods, chClusterPod)
+			}
+		}
+		return nil
+	})
+	for _, werr := range errs {
+		if werr != nil {
+			return nil, werr
+		}
+	}
+	var externalURL string
+	var services *v1.ServiceList
+	services, err = getK8sServicesFromLabelSelector(k, chi.Namespace, &metav1.LabelSelector{
+		MatchLabels: map[string]string{
+			"clickhouse.altinity.com/chi": chi.Name,
+		},
+	})
+	if err == nil {
+		for _, svc := range services.Items {
+			if _, ok := svc.Labels["clickhouse.altinity.com/cluster"]; !ok && svc.Spec.Type == "LoadBalancer" {
+				for _, ing := range svc.Status.LoadBalancer.Ingress {
+					externalHost := ""
+					if ing.Hostname != "" {
+						externalHost = ing.Hostname
+					} else if ing.IP != "" {
+						externalHost = ing.IP
+					}
+					if externalHost == "" {
+						continue
+					}
+					for _, port := range svc.Spec.Ports {
+						if port.Name == "http" {
+							externalURL = fmt.Sprintf("http://%s:%d", externalHost, port.Port)
 							break
 						}
 					}
+					if externalURL != "" {
+						break
+					}
 				}
 			}
 		}
-		var y []byte
-		y, err = yaml.Marshal(ResourceSpec{
-			APIVersion: chi.APIVersion,
-			Kind:       chi.Kind,
-			Metadata: ResourceSpecMetadata{
-				Name:            chi.Name,
-				Namespace:       chi.Namespace,
-				ResourceVersion: chi.ResourceVersion,
-			},
-			Spec: chi.Spec,
-		})
-		if err != nil {
-			y = nil
-		}
-		list = append(list, Chi{
-			Name:          chi.Name,
-			Namespace:     chi.Namespace,
-			Status:        chi.Status.Status,
-			Clusters:      chi.Status.ClustersCount,
-			Hosts:         chi.Status.HostsCount,
-			ExternalURL:   externalURL,
-			ResourceYAML:  string(y),
-			CHClusterPods: chClusterPods,
-		})
 	}
-	_ = response.WriteEntity(list)
+	var y []byte
+	y, err = chiResourceYAML(chi)
+	if err != nil {
+		y = nil
+	}
+	return &Chi{
+		Name:          chi.Name,
+		Namespace:     chi.Namespace,
+		Status:        chi.Status.Status,
+		Clusters:      chi.Status.ClustersCount,
+		Hosts:         chi.Status.HostsCount,
+		ExternalURL:   externalURL,
+		ResourceYAML:  string(y),
+		CHClusterPods: chClusterPods,
+	}, nil
+}
+
+// chiResourceYAML returns the user-editable YAML spec of a CHI
+func chiResourceYAML(chi *chopv1.ClickHouseInstallation) ([]byte, error) {
+	// Objects received from a watch do not have their type set
+	apiVersion := chi.APIVersion
+	if apiVersion == "" {
+		apiVersion = chopv1.SchemeGroupVersion.String()
+	}
+	kind := chi.Kind
+	if kind == "" {
+		kind = "ClickHouseInstallation"
+	}
+	return yaml.Marshal(ResourceSpec{
+		APIVersion: apiVersion,
+		Kind:       kind,
+		Metadata: ResourceSpecMetadata{
+			Name:            chi.Name,
+			Namespace:       chi.Namespace,
+			ResourceVersion: chi.ResourceVersion,
+		},
+		Spec: chi.Spec,
+	})
+}
+
+// getCHIYAML gets the YAML spec of an existing CHI, or an empty string if it can't be retrieved
+func getCHIYAML(k *utils.K8s, namespace string, name string) string {
+	chi, err := k.ChopClientset.ClickhouseV1().ClickHouseInstallations(namespace).Get(
+		context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		return ""
+	}
+	y, err := chiResourceYAML(chi)
+	if err != nil {
+		return ""
+	}
+	return string(y)
 }
 
 var ErrNamespaceRequired = errors.New("namespace is required")
@@ -234,47 +293,86 @@ var ErrYAMLMustBeCHI = errors.New("YAML document must contain a single Clickhous
 func (c *ChiResource) handlePostOrPatchCHI(request *restful.Request, response *restful.Response, doPost bool) {
 	namespace, ok := request.PathParameters()["namespace"]
 	if !ok || namespace == "" {
-		webError(response, http.StatusBadRequest, ErrNamespaceRequired)
+		webError(request, response, http.StatusBadRequest, ErrNamespaceRequired)
 		return
 	}
 	name := ""
 	if !doPost {
 		name, ok = request.PathParameters()["name"]
 		if !ok || name == "" {
-			webError(response, http.StatusBadRequest, ErrNameRequired)
+			webError(request, response, http.StatusBadRequest, ErrNameRequired)
 			return
 		}
 	}
 
+	dryRun, err := isDryRun(request)
+	if err != nil {
+		webError(request, response, http.StatusBadRequest, err)
+		return
+	}
 	putParams := ChiPutParams{}
-	err := request.ReadEntity(&putParams)
+	err = request.ReadEntity(&putParams)
 	if err != nil {
-		webError(response, http.StatusBadRequest, err)
+		webError(request, response, http.StatusBadRequest, err)
 		return
 	}
 
-	k := utils.GetK8s()
+	k, err := getK8s(request)
+	if err != nil {
+		webError(request, response, http.StatusInternalServerError, err)
+		return
+	}
 	defer func() { k.ReleaseK8s() }()
 	var obj *unstructured.Unstructured
 	obj, err = utils.DecodeYAMLToObject(putParams.YAML)
 	if err != nil {
-		webError(response, http.StatusBadRequest, err)
+		webError(request, response, http.StatusBadRequest, err)
 		return
 	}
 	if obj.GetAPIVersion() != "clickhouse.altinity.com/v1" ||
 		obj.GetKind() != "ClickHouseInstallation" ||
 		(!doPost && (obj.GetNamespace() != namespace ||
 			obj.GetName() != name)) {
-		webError(response, http.StatusBadRequest, ErrYAMLMustBeCHI)
+		webError(request, response, http.StatusBadRequest, ErrYAMLMustBeCHI)
 		return
 	}
 	if doPost {
-		err = k.SingleObjectCreate(obj, namespace)
+		setAuditYAML(request, "", putParams.YAML)
 	} else {
-		err = k.SingleObjectUpdate(obj, namespace)
+		setAuditYAML(request, getCHIYAML(k, namespace, name), putParams.YAML)
+	}
+	var result *unstructured.Unstructured
+	if doPost {
+		result, err = k.SingleObjectCreate(obj, namespace, dryRun)
+	} else {
+		result, err = k.SingleObjectUpdate(obj, namespace, dryRun)
+		if errors2.IsConflict(err) {
+			conflictErr := err
+			var conflict *ChiConflict
+			result, conflict, err = resolveConflict(k, obj, &putParams, dryRun)
+			if conflict != nil {
+				if requestID := recordError(request, http.StatusConflict, conflictErr); requestID != "" {
+					conflict.Error = fmt.Sprintf("%s (request ID %s)", conflict.Error, requestID)
+				}
+				_ = response.WriteHeaderAndEntity(http.StatusConflict, conflict)
+				return
+			}
+			if errors.Is(err, ErrOriginalYAMLInvalid) {
+				webError(request, response, http.StatusBadRequest, err)
+				return
+			}
+		}
+	}
+	if dryRun && !errors.Is(err, utils.ErrOperatorNotDeployed) {
+		var results []*unstructured.Unstructured
+		if result != nil {
+			results = append(results, result)
+		}
+		writeDryRunResult(request, response, results, err)
+		return
 	}
 	if err != nil {
-		webError(response, http.StatusInternalServerError, err)
+		webError(request, response, http.StatusInternalServerError, err)
 		return
 	}
 	_ = response.WriteEntity(nil)
@@ -291,23 +389,28 @@ func (c *ChiResource) handlePatchCHI(request *restful.Request, response *restful
 func (c *ChiResource) handleDeleteCHI(request *restful.Request, response *restful.Response) {
 	namespace, ok := request.PathParameters()["namespace"]
 	if !ok || namespace == "" {
-		webError(response, http.StatusBadRequest, ErrNamespaceRequired)
+		webError(request, response, http.StatusBadRequest, ErrNamespaceRequired)
 		return
 	}
 	var name string
 	name, ok = request.PathParameters()["name"]
 	if !ok || name == "" {
-		webError(response, http.StatusBadRequest, ErrNameRequired)
+		webError(request, response, http.StatusBadRequest, ErrNameRequired)
 		return
 	}
 
-	k := utils.GetK8s()
+	k, err := getK8s(request)
+	if err != nil {
+		webError(request, response, http.StatusInternalServerError, err)
+		return
+	}
 	defer func() { k.ReleaseK8s() }()
-	err := k.ChopClientset.ClickhouseV1().
+	setAuditYAML(request, getCHIYAML(k, namespace, name), "")
+	err = k.ChopClientset.ClickhouseV1().
 		ClickHouseInstallations(namespace).
 		Delete(context.TODO(), name, metav1.DeleteOptions{})
 	if err != nil {
-		webError(response, http.StatusInternalServerError, err)
+		webError(request, response, http.StatusInternalServerError, err)
 		return
 	}
 
@@ -0,0 +1,233 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/altinity/altinity-dashboard/internal/utils"
+	chopv1 "github.com/altinity/clickhouse-operator/pkg/apis/clickhouse.altinity.com/v1"
+	"github.com/emicklei/go-restful/v3"
+	errors2 "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sigs.k8s.io/yaml"
+	"sort"
+	"strings"
+)
+
+// restartFields are the fields of a CHI spec that change the pods themselves, so the operator has to
+// recreate them, rather than only updating ClickHouse's configuration
+var restartFields = map[string]bool{
+	"podTemplates":            true,
+	"podTemplate":             true,
+	"volumeClaimTemplates":    true,
+	"volumeClaimTemplate":     true,
+	"dataVolumeClaimTemplate": true,
+	"logVolumeClaimTemplate":  true,
+	"image":                   true,
+}
+
+var pathIndexRegexp = regexp.MustCompile(`\[[^]]*]`)
+
+// causesRestart reports whether a change at the given path causes pods to be restarted
+func causesRestart(path string) bool {
+	for _, field := range strings.Split(pathIndexRegexp.ReplaceAllString(path, ""), ".") {
+		if restartFields[field] {
+			return true
+		}
+	}
+	return false
+}
+
+// toGeneric converts a value to the maps, slices and scalars it would be decoded to from JSON
+func toGeneric(v interface{}) (interface{}, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var g interface{}
+	err = json.Unmarshal(data, &g)
+	if err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// namedItems returns the items of a list by name, if they are all objects with distinct names
+func namedItems(list []interface{}) (map[string]interface{}, []string, bool) {
+	items := make(map[string]interface{}, len(list))
+	names := make([]string, 0, len(list))
+	for _, item := range list {
+		m, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, nil, false
+		}
+		name, ok := m["name"].(string)
+		if !ok {
+			return nil, nil, false
+		}
+		if _, dup := items[name]; dup {
+			return nil, nil, false
+		}
+		items[name] = item
+		names = append(names, name)
+	}
+	return items, names, true
+}
+
+// diffValues appends the changes from before to after, which are generic values at the given path.  Lists of
+// named objects, such as templates and clusters, are compared by name rather than by position.
+func diffValues(path string, before interface{}, after interface{}, changes *[]ChiChange) {
+	add := func(changeType string) {
+		*changes = append(*changes, ChiChange{
+			Path:    path,
+			Type:    changeType,
+			Old:     before,
+			New:     after,
+			Restart: causesRestart(path),
+		})
+	}
+	switch {
+	case before == nil && after == nil:
+		return
+	case before == nil:
+		add("added")
+		return
+	case after == nil:
+		add("removed")
+		return
+	}
+	switch o := before.(type) {
+	case map[string]interface{}:
+		n, ok := after.(map[string]interface{})
+		if !ok {
+			add("modified")
+			return
+		}
+		keys := make([]string, 0, len(o)+len(n))
+		for key := range o {
+			keys = append(keys, key)
+		}
+		for key := range n {
+			if _, ok := o[key]; !ok {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			diffValues(path+"."+key, o[key], n[key], changes)
+		}
+	case []interface{}:
+		n, ok := after.([]interface{})
+		if !ok {
+			add("modified")
+			return
+		}
+		oItems, oNames, oNamed := namedItems(o)
+		nItems, nNames, nNamed := namedItems(n)
+		if oNamed && nNamed {
+			names := oNames
+			for _, name := range nNames {
+				if _, ok := oItems[name]; !ok {
+					names = append(names, name)
+				}
+			}
+			for _, name := range names {
+				diffValues(fmt.Sprintf("%s[name=%s]", path, name), oItems[name], nItems[name], changes)
+			}
+			return
+		}
+		for i := 0; i < len(o) || i < len(n); i++ {
+			var oi, ni interface{}
+			if i < len(o) {
+				oi = o[i]
+			}
+			if i < len(n) {
+				ni = n[i]
+			}
+			diffValues(fmt.Sprintf("%s[%d]", path, i), oi, ni, changes)
+		}
+	default:
+		if !reflect.DeepEqual(before, after) {
+			add("modified")
+		}
+	}
+}
+
+// diffCHISpecs compares the live and submitted specs of a CHI
+func diffCHISpecs(live *chopv1.ChiSpec, submitted *chopv1.ChiSpec) (*ChiDiff, error) {
+	// Round-trip both specs through the same types, so that fields are formatted and defaulted the same way
+	liveSpec, err := toGeneric(live)
+	if err != nil {
+		return nil, err
+	}
+	submittedSpec, err := toGeneric(submitted)
+	if err != nil {
+		return nil, err
+	}
+	d := &ChiDiff{Changes: make([]ChiChange, 0)}
+	diffValues("spec", liveSpec, submittedSpec, &d.Changes)
+	for _, c := range d.Changes {
+		if c.Restart {
+			d.RestartRequired = true
+		}
+	}
+	liveYAML, err := yaml.Marshal(map[string]interface{}{"spec": liveSpec})
+	if err != nil {
+		return nil, err
+	}
+	submittedYAML, err := yaml.Marshal(map[string]interface{}{"spec": submittedSpec})
+	if err != nil {
+		return nil, err
+	}
+	d.Unified = utils.UnifiedDiff("live", "submitted", string(liveYAML), string(submittedYAML))
+	return d, nil
+}
+
+func (c *ChiResource) handleDiffCHI(request *restful.Request, response *restful.Response) {
+	skipAudit(request)
+	namespace := request.PathParameter("namespace")
+	name := request.PathParameter("name")
+	putParams := ChiPutParams{}
+	err := request.ReadEntity(&putParams)
+	if err != nil {
+		webError(request, response, http.StatusBadRequest, err)
+		return
+	}
+	submitted := chopv1.ClickHouseInstallation{}
+	err = yaml.Unmarshal([]byte(putParams.YAML), &submitted)
+	if err != nil {
+		webError(request, response, http.StatusBadRequest, err)
+		return
+	}
+	if submitted.APIVersion != "clickhouse.altinity.com/v1" || submitted.Kind != "ClickHouseInstallation" ||
+		submitted.Namespace != namespace || submitted.Name != name {
+		webError(request, response, http.StatusBadRequest, ErrYAMLMustBeCHI)
+		return
+	}
+
+	k, err := getK8s(request)
+	if err != nil {
+		webError(request, response, http.StatusInternalServerError, err)
+		return
+	}
+	defer func() { k.ReleaseK8s() }()
+	live, err := k.ChopClientset.ClickhouseV1().ClickHouseInstallations(namespace).Get(
+		context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		if errors2.IsNotFound(err) {
+			webError(request, response, http.StatusNotFound, err)
+			return
+		}
+		webError(request, response, http.StatusInternalServerError, err)
+		return
+	}
+	d, err := diffCHISpecs(&live.Spec, &submitted.Spec)
+	if err != nil {
+		webError(request, response, http.StatusInternalServerError, err)
+		return
+	}
+	_ = response.WriteEntity(d)
+}
@@ -0,0 +1,134 @@
+package api
+
+import (
+	"reflect"
+	"sigs.k8s.io/yaml"
+	"testing"
+)
+
+// fromYAML decodes YAML into the generic values diffValues compares
+func fromYAML(t *testing.T, s string) interface{} {
+	t.Helper()
+	var v interface{}
+	if err := yaml.Unmarshal([]byte(s), &v); err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestCausesRestart(t *testing.T) {
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"spec.templates.podTemplates[name=default].spec.containers[name=clickhouse].image", true},
+		{"spec.templates.podTemplates[name=default]", true},
+		{"spec.defaults.templates.dataVolumeClaimTemplate", true},
+		{"spec.configuration.clusters[name=main].templates.podTemplate", true},
+		{"spec.configuration.clusters[name=main].layout.replicasCount", false},
+		{"spec.configuration.settings.max_connections", false},
+		{"spec.configuration.users[name=image].password", false},
+		{"spec.configuration.files[0]", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			if got := causesRestart(tt.path); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiffValues(t *testing.T) {
+	type change struct {
+		path       string
+		changeType string
+		restart    bool
+	}
+	tests := []struct {
+		name   string
+		before string
+		after  string
+		want   []change
+	}{
+		{
+			name:   "same",
+			before: "configuration: {settings: {a: 1}}",
+			after:  "configuration: {settings: {a: 1}}",
+		},
+		{
+			name:   "scalars added, removed and modified",
+			before: "configuration: {settings: {a: 1, b: x}}",
+			after:  "configuration: {settings: {a: 2, c: true}}",
+			want: []change{
+				{"spec.configuration.settings.a", "modified", false},
+				{"spec.configuration.settings.b", "removed", false},
+				{"spec.configuration.settings.c", "added", false},
+			},
+		},
+		{
+			name: "named list compared by name",
+			before: `configuration:
+  clusters:
+  - {name: a, layout: {shardsCount: 1}}
+  - {name: b, layout: {shardsCount: 1}}`,
+			after: `configuration:
+  clusters:
+  - {name: b, layout: {shardsCount: 2}}
+  - {name: c}
+  - {name: a, layout: {shardsCount: 1}}`,
+			want: []change{
+				{"spec.configuration.clusters[name=b].layout.shardsCount", "modified", false},
+				{"spec.configuration.clusters[name=c]", "added", false},
+			},
+		},
+		{
+			name:   "unnamed list compared by position",
+			before: "configuration: {files: [a, b]}",
+			after:  "configuration: {files: [a, c, d]}",
+			want: []change{
+				{"spec.configuration.files[1]", "modified", false},
+				{"spec.configuration.files[2]", "added", false},
+			},
+		},
+		{
+			name: "nested pod template image",
+			before: `templates:
+  podTemplates:
+  - name: default
+    spec:
+      containers:
+      - {name: clickhouse, image: "clickhouse/clickhouse-server:23.8"}`,
+			after: `templates:
+  podTemplates:
+  - name: default
+    spec:
+      containers:
+      - {name: clickhouse, image: "clickhouse/clickhouse-server:24.3"}`,
+			want: []change{
+				{"spec.templates.podTemplates[name=default].spec.containers[name=clickhouse].image", "modified", true},
+			},
+		},
+		{
+			name:   "type changed",
+			before: "configuration: {settings: {a: [1]}}",
+			after:  "configuration: {settings: {a: {b: 1}}}",
+			want: []change{
+				{"spec.configuration.settings.a", "modified", false},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var changes []ChiChange
+			diffValues("spec", fromYAML(t, tt.before), fromYAML(t, tt.after), &changes)
+			var got []change
+			for _, c := range changes {
+				got = append(got, change{c.Path, c.Type, c.Restart})
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,183 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/altinity/altinity-dashboard/internal/utils"
+	chopv1 "github.com/altinity/clickhouse-operator/pkg/apis/clickhouse.altinity.com/v1"
+	errors2 "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"reflect"
+	"sigs.k8s.io/yaml"
+	"sort"
+)
+
+var ErrOriginalYAMLInvalid = errors.New("original_yaml is not a valid ClickHouseInstallation")
+
+// mergeValues merges the changes from base to ours with the changes from base to theirs, which are generic
+// values at the given path.  Where both changed a field differently, the field is appended to conflicts and
+// theirs is kept.  Lists of named objects are merged by name; other lists are only merged if one side left
+// them unchanged.
+func mergeValues(path string, base interface{}, ours interface{}, theirs interface{},
+	conflicts *[]ChiConflictField) interface{} {
+	switch {
+	case reflect.DeepEqual(ours, theirs):
+		return ours
+	case reflect.DeepEqual(base, ours):
+		return theirs
+	case reflect.DeepEqual(base, theirs):
+		return ours
+	}
+
+	// Both sides changed the value, so try to merge its contents
+	bm, bIsMap := base.(map[string]interface{})
+	om, oIsMap := ours.(map[string]interface{})
+	tm, tIsMap := theirs.(map[string]interface{})
+	if oIsMap && tIsMap && (base == nil || bIsMap) {
+		seen := make(map[string]bool)
+		keys := make([]string, 0, len(bm)+len(om)+len(tm))
+		for _, m := range []map[string]interface{}{bm, om, tm} {
+			for key := range m {
+				if !seen[key] {
+					seen[key] = true
+					keys = append(keys, key)
+				}
+			}
+		}
+		sort.Strings(keys)
+		merged := make(map[string]interface{})
+		for _, key := range keys {
+			v := mergeValues(path+"."+key, bm[key], om[key], tm[key], conflicts)
+			if v != nil {
+				merged[key] = v
+			}
+		}
+		return merged
+	}
+	bl, bIsList := base.([]interface{})
+	ol, oIsList := ours.([]interface{})
+	tl, tIsList := theirs.([]interface{})
+	if oIsList && tIsList && (base == nil || bIsList) {
+		bItems, _, bNamed := namedItems(bl)
+		oItems, oNames, oNamed := namedItems(ol)
+		tItems, tNames, tNamed := namedItems(tl)
+		if bNamed && oNamed && tNamed {
+			// Keep the current order, followed by items only we added.  Items that either side removed, and
+			// the other left unchanged, merge to nil and are dropped.
+			names := tNames
+			for _, name := range oNames {
+				if _, ok := tItems[name]; !ok {
+					names = append(names, name)
+				}
+			}
+			merged := make([]interface{}, 0, len(names))
+			for _, name := range names {
+				v := mergeValues(fmt.Sprintf("%s[name=%s]", path, name), bItems[name], oItems[name], tItems[name],
+					conflicts)
+				if v != nil {
+					merged = append(merged, v)
+				}
+			}
+			return merged
+		}
+	}
+
+	*conflicts = append(*conflicts, ChiConflictField{
+		Path:     path,
+		Original: base,
+		Yours:    ours,
+		Current:  theirs,
+	})
+	return theirs
+}
+
+// resolveConflict handles an update of a CHI that was rejected because the CHI had changed since the
+// submitted YAML was loaded.  If the edit can be merged with the changes made since, and the request asks
+// for that, the merged CHI is saved and returned.  Otherwise, the conflict is returned, to be reported to
+// the user.  The caller must hold a GetK8s() reference.
+func resolveConflict(k *utils.K8s, obj *unstructured.Unstructured, params *ChiPutParams,
+	dryRun bool) (*unstructured.Unstructured, *ChiConflict, error) {
+	// The CHI is read and merged without going through the clickhouse-operator types, so that fields they
+	// don't know about, from a newer CRD, are kept
+	current, err := k.DynamicClient.Resource(chopv1.SchemeGroupVersion.WithResource(utils.ResourceCHIs)).
+		Namespace(obj.GetNamespace()).Get(context.TODO(), obj.GetName(), metav1.GetOptions{})
+	if err != nil {
+		return nil, nil, err
+	}
+	merged, conflict, err := mergeCHISpec(current, obj, params)
+	if err != nil || conflict != nil {
+		return nil, conflict, err
+	}
+
+	// Save the merged spec over the current version
+	obj.Object["spec"] = merged
+	obj.SetResourceVersion(current.GetResourceVersion())
+	result, err := k.SingleObjectUpdate(obj, obj.GetNamespace(), dryRun)
+	if errors2.IsConflict(err) {
+		conflict, err = newChiConflict(current)
+		if err != nil {
+			return nil, nil, err
+		}
+		conflict.Error = "the ClickHouse Installation was changed again while merging; try again"
+		return nil, conflict, nil
+	}
+	return result, nil, err
+}
+
+// newChiConflict returns a conflict with the current version of a CHI, and no conflicting fields
+func newChiConflict(current *unstructured.Unstructured) (*ChiConflict, error) {
+	// Like chiResourceYAML, but keeping the whole spec
+	currentYAML, err := yaml.Marshal(ResourceSpec{
+		APIVersion: current.GetAPIVersion(),
+		Kind:       current.GetKind(),
+		Metadata: ResourceSpecMetadata{
+			Name:            current.GetName(),
+			Namespace:       current.GetNamespace(),
+			ResourceVersion: current.GetResourceVersion(),
+		},
+		Spec: current.Object["spec"],
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &ChiConflict{
+		Error:       "the ClickHouse Installation has been changed since it was loaded",
+		CurrentYAML: string(currentYAML),
+	}, nil
+}
+
+// mergeCHISpec merges the edit in the submitted CHI with the changes made to the current version since the
+// original in the request was loaded, and returns the merged spec.  If there is no original, the edits
+// conflict, or the request doesn't ask for them to be merged, the conflict is returned instead.
+func mergeCHISpec(current *unstructured.Unstructured, submitted *unstructured.Unstructured,
+	params *ChiPutParams) (interface{}, *ChiConflict, error) {
+	conflict, err := newChiConflict(current)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Without the original, there is no telling which side changed what
+	if params.OriginalYAML == "" {
+		conflict.Error += "; include original_yaml to find out whether the edits overlap"
+		return nil, conflict, nil
+	}
+
+	original, err := utils.DecodeYAMLToObject(params.OriginalYAML)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %s", ErrOriginalYAMLInvalid, err)
+	}
+	merged := mergeValues("spec", original.Object["spec"], submitted.Object["spec"], current.Object["spec"],
+		&conflict.Conflicts)
+	if len(conflict.Conflicts) > 0 {
+		conflict.Error += ", and some of the same fields were changed"
+		return nil, conflict, nil
+	}
+	if !params.Merge {
+		conflict.Error += ", but none of the same fields were changed, so the edits can be merged"
+		conflict.Mergeable = true
+		return nil, conflict, nil
+	}
+	return merged, nil, nil
+}
@@ -0,0 +1,231 @@
+package api
+
+import (
+	"errors"
+	"github.com/altinity/altinity-dashboard/internal/utils"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMergeValues(t *testing.T) {
+	tests := []struct {
+		name      string
+		base      string
+		ours      string
+		theirs    string
+		want      string
+		conflicts []string
+	}{
+		{
+			name:   "different fields",
+			base:   "{a: 1, b: 1, c: 1}",
+			ours:   "{a: 2, b: 1}",
+			theirs: "{a: 1, b: 2, c: 1, d: 1}",
+			want:   "{a: 2, b: 2, d: 1}",
+		},
+		{
+			name:      "same field",
+			base:      "{a: 1, b: 1}",
+			ours:      "{a: 2, b: 1}",
+			theirs:    "{a: 3, b: 2}",
+			want:      "{a: 3, b: 2}",
+			conflicts: []string{"spec.a"},
+		},
+		{
+			name:   "same field changed the same way",
+			base:   "{a: 1}",
+			ours:   "{a: 2}",
+			theirs: "{a: 2}",
+			want:   "{a: 2}",
+		},
+		{
+			name:      "field changed and removed",
+			base:      "{a: {b: 1}}",
+			ours:      "{a: {b: 2}}",
+			theirs:    "{a: {}}",
+			want:      "{a: {}}",
+			conflicts: []string{"spec.a.b"},
+		},
+		{
+			name:   "named list items changed, added and removed",
+			base:   "{clusters: [{name: a, x: 1}, {name: b, x: 1}, {name: c}]}",
+			ours:   "{clusters: [{name: a, x: 2}, {name: b, x: 1}, {name: d}]}",
+			theirs: "{clusters: [{name: b, x: 2}, {name: a, x: 1}, {name: c}, {name: e}]}",
+			want:   "{clusters: [{name: b, x: 2}, {name: a, x: 2}, {name: e}, {name: d}]}",
+		},
+		{
+			name:      "named list item changed on both sides",
+			base:      "{clusters: [{name: a, x: 1}]}",
+			ours:      "{clusters: [{name: a, x: 2}]}",
+			theirs:    "{clusters: [{name: a, x: 3}]}",
+			want:      "{clusters: [{name: a, x: 3}]}",
+			conflicts: []string{"spec.clusters[name=a].x"},
+		},
+		{
+			name:   "unnamed list changed on one side",
+			base:   "{files: [a, b]}",
+			ours:   "{files: [a, b, c]}",
+			theirs: "{files: [a, b], x: 1}",
+			want:   "{files: [a, b, c], x: 1}",
+		},
+		{
+			name:      "unnamed list changed on both sides",
+			base:      "{files: [a, b]}",
+			ours:      "{files: [a, b, c]}",
+			theirs:    "{files: [b]}",
+			want:      "{files: [b]}",
+			conflicts: []string{"spec.files"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var conflicts []ChiConflictField
+			got := mergeValues("spec", fromYAML(t, tt.base), fromYAML(t, tt.ours), fromYAML(t, tt.theirs), &conflicts)
+			if want := fromYAML(t, tt.want); !reflect.DeepEqual(got, want) {
+				t.Errorf("got %v, want %v", got, want)
+			}
+			var paths []string
+			for _, c := range conflicts {
+				paths = append(paths, c.Path)
+			}
+			if !reflect.DeepEqual(paths, tt.conflicts) {
+				t.Errorf("got conflicts %v, want %v", paths, tt.conflicts)
+			}
+		})
+	}
+}
+
+// chiYAML returns the YAML of a CHI with the given spec
+func chiYAML(spec string) string {
+	return "apiVersion: clickhouse.altinity.com/v1\nkind: ClickHouseInstallation\n" +
+		"metadata: {name: test, namespace: default, resourceVersion: \"2\"}\nspec: " + spec + "\n"
+}
+
+// decodeCHI decodes a CHI with the given spec, as the API server or the request would give it
+func decodeCHI(t *testing.T, spec string) *unstructured.Unstructured {
+	t.Helper()
+	obj, err := utils.DecodeYAMLToObject(chiYAML(spec))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return obj
+}
+
+func TestMergeCHISpec(t *testing.T) {
+	tests := []struct {
+		name      string
+		original  string
+		yours     string
+		current   string
+		merge     bool
+		want      string
+		conflict  bool
+		mergeable bool
+		conflicts []ChiConflictField
+		wantErr   error
+		wantMsg   string
+	}{
+		{
+			name:     "no original",
+			yours:    "{taskID: b, useTemplates: [{name: t1}, {name: t2}]}",
+			current:  "{taskID: a, useTemplates: [{name: t1}]}",
+			merge:    true,
+			conflict: true,
+			wantMsg:  "include original_yaml",
+		},
+		{
+			name:     "merged",
+			original: "{taskID: a, useTemplates: [{name: t1}]}",
+			yours:    "{taskID: a, useTemplates: [{name: t1}, {name: t2}]}",
+			current:  "{taskID: b, useTemplates: [{name: t1}]}",
+			merge:    true,
+			want:     "{taskID: b, useTemplates: [{name: t1}, {name: t2}]}",
+		},
+		{
+			name:     "fields unknown to the operator types kept",
+			original: "{taskID: a, newSetting: {x: 1}}",
+			yours:    "{taskID: b, newSetting: {x: 1}}",
+			current:  "{taskID: a, newSetting: {x: 2}, newerSetting: [y]}",
+			merge:    true,
+			want:     "{taskID: b, newSetting: {x: 2}, newerSetting: [y]}",
+		},
+		{
+			name:      "mergeable but not asked to merge",
+			original:  "{taskID: a}",
+			yours:     "{taskID: a, useTemplates: [{name: t1}]}",
+			current:   "{taskID: b}",
+			conflict:  true,
+			mergeable: true,
+			wantMsg:   "so the edits can be merged",
+		},
+		{
+			name:     "same field changed",
+			original: "{taskID: a}",
+			yours:    "{taskID: b}",
+			current:  "{taskID: c}",
+			merge:    true,
+			conflict: true,
+			conflicts: []ChiConflictField{
+				{Path: "spec.taskID", Original: "a", Yours: "b", Current: "c"},
+			},
+			wantMsg: "some of the same fields were changed",
+		},
+		{
+			name:     "invalid original",
+			original: "[",
+			yours:    "{taskID: b}",
+			current:  "{taskID: a}",
+			wantErr:  ErrOriginalYAMLInvalid,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			current := decodeCHI(t, tt.current)
+			params := &ChiPutParams{YAML: chiYAML(tt.yours), Merge: tt.merge}
+			if tt.original == "[" {
+				params.OriginalYAML = tt.original
+			} else if tt.original != "" {
+				params.OriginalYAML = chiYAML(tt.original)
+			}
+			merged, conflict, err := mergeCHISpec(current, decodeCHI(t, tt.yours), params)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if tt.want != "" {
+				if want := decodeCHI(t, tt.want).Object["spec"]; !reflect.DeepEqual(merged, want) {
+					t.Errorf("got %v, want %v", merged, want)
+				}
+			}
+			if !tt.conflict {
+				if conflict != nil {
+					t.Errorf("got conflict %+v, want none", conflict)
+				}
+				return
+			}
+			if conflict == nil {
+				t.Fatal("got no conflict")
+			}
+			if merged != nil {
+				t.Errorf("got merged spec %v with a conflict", merged)
+			}
+			if !reflect.DeepEqual(conflict.Conflicts, tt.conflicts) {
+				t.Errorf("got conflicts %+v, want %+v", conflict.Conflicts, tt.conflicts)
+			}
+			if conflict.Mergeable != tt.mergeable {
+				t.Errorf("got mergeable %v, want %v", conflict.Mergeable, tt.mergeable)
+			}
+			if !strings.Contains(conflict.Error, tt.wantMsg) {
+				t.Errorf("got error message %q, want it to contain %q", conflict.Error, tt.wantMsg)
+			}
+			got, err := utils.DecodeYAMLToObject(conflict.CurrentYAML)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got.Object["spec"], current.Object["spec"]) || got.GetResourceVersion() != "2" {
+				t.Errorf("got current YAML %q", conflict.CurrentYAML)
+			}
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package api
+
+import (
+	"github.com/altinity/altinity-dashboard/internal/auth"
+	"github.com/altinity/altinity-dashboard/internal/utils"
+	"github.com/emicklei/go-restful/v3"
+)
+
+// ClusterResource is the REST layer to the configured Kubernetes clusters
+type ClusterResource struct {
+}
+
+// Name returns the name of the web service
+func (c *ClusterResource) Name() string {
+	return "Clusters"
+}
+
+// WebService creates a new service that can handle REST requests
+func (c *ClusterResource) WebService(wsi *WebServiceInfo) (*restful.WebService, error) {
+	ws := newWebService(wsi, "/clusters")
+	ws.
+		Produces(restful.MIME_JSON).
+		Filter(requireRole(auth.RoleViewer))
+
+	ws.Route(ws.GET("").To(c.getClusters).
+		Doc("get all clusters").
+		Writes([]Cluster{}).
+		Returns(200, "OK", []Cluster{}))
+
+	return ws, nil
+}
+
+func (c *ClusterResource) getClusters(_ *restful.Request, response *restful.Response) {
+	list := make([]Cluster, 0, len(utils.ClusterNames()))
+	for _, name := range utils.ClusterNames() {
+		k, err := utils.GetClusterK8s(name)
+		if err != nil {
+			continue
+		}
+		list = append(list, Cluster{
+			Name:        name,
+			KubeCluster: k.Config.Host,
+			Default:     name == utils.DefaultCluster(),
+		})
+		k.ReleaseK8s()
+	}
+	_ = response.WriteEntity(list)
+}
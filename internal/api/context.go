@@ -0,0 +1,122 @@
+package api
+
+import (
+	"errors"
+	"github.com/altinity/altinity-dashboard/internal/auth"
+	"github.com/altinity/altinity-dashboard/internal/utils"
+	"github.com/emicklei/go-restful/v3"
+	"net/http"
+	"sort"
+)
+
+// ContextResource is the REST layer to kubeconfig contexts
+type ContextResource struct {
+}
+
+// ContextPutParams is the object for parameters to a context PUT request
+type ContextPutParams struct {
+	Name string `json:"name" description:"name of the context to switch to"`
+}
+
+// Name returns the name of the web service
+func (c *ContextResource) Name() string {
+	return "Contexts"
+}
+
+// WebService creates a new service that can handle REST requests
+func (c *ContextResource) WebService(wsi *WebServiceInfo) (*restful.WebService, error) {
+	ws := newWebService(wsi, "/contexts")
+	ws.
+		Consumes(restful.MIME_JSON).
+		Produces(restful.MIME_JSON).
+		Filter(requireRole(auth.RoleViewer)).
+		Filter(auditMutations(wsi.Audit))
+
+	ws.Route(ws.GET("").To(c.getContexts).
+		Doc("get the contexts in the kubeconfig").
+		Writes([]KubeContext{}).
+		Returns(200, "OK", []KubeContext{}))
+
+	ws.Route(ws.PUT("/active").To(c.putActiveContext).
+		Filter(requireRole(auth.RoleAdmin)).
+		Doc("switch to a different context").
+		Reads(ContextPutParams{}).
+		Returns(200, "OK", []KubeContext{}))
+
+	return ws, nil
+}
+
+// getClusterContexts lists the kubeconfig contexts of a cluster
+func getClusterContexts(cluster string) ([]KubeContext, error) {
+	k, err := utils.GetClusterK8s(cluster)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { k.ReleaseK8s() }()
+	raw, active, err := k.Kubeconfig()
+	if err != nil {
+		return nil, err
+	}
+	list := make([]KubeContext, 0, len(raw.Contexts))
+	for name, ctx := range raw.Contexts {
+		kc := KubeContext{
+			Name:      name,
+			Cluster:   ctx.Cluster,
+			Namespace: ctx.Namespace,
+			User:      ctx.AuthInfo,
+			Active:    name == active,
+		}
+		if cl, ok := raw.Clusters[ctx.Cluster]; ok {
+			kc.Server = cl.Server
+		}
+		list = append(list, kc)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list, nil
+}
+
+func (c *ContextResource) getContexts(request *restful.Request, response *restful.Response) {
+	list, err := getClusterContexts(request.PathParameter("cluster"))
+	if err != nil {
+		webError(request, response, http.StatusInternalServerError, err)
+		return
+	}
+	_ = response.WriteEntity(list)
+}
+
+func (c *ContextResource) putActiveContext(request *restful.Request, response *restful.Response) {
+	params := ContextPutParams{}
+	err := request.ReadEntity(&params)
+	if err != nil {
+		webError(request, response, http.StatusBadRequest, err)
+		return
+	}
+	setAuditTarget(request, params.Name)
+
+	// The switch is made on the cluster's own client, which any impersonating clients are derived from
+	cluster := request.PathParameter("cluster")
+	k, err := utils.GetClusterK8s(cluster)
+	if err != nil {
+		webError(request, response, http.StatusInternalServerError, err)
+		return
+	}
+	k.ReleaseK8s()
+	err = k.UseContext(params.Name)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, utils.ErrUnknownContext) {
+			status = http.StatusBadRequest
+		}
+		webError(request, response, status, err)
+		return
+	}
+
+	list, err := getClusterContexts(cluster)
+	if err != nil {
+		webError(request, response, http.StatusInternalServerError, err)
+		return
+	}
+	_ = response.WriteEntity(list)
+}
@@ -1,13 +1,16 @@
 package api
 
 import (
-	"context"
+	"github.com/altinity/altinity-dashboard/internal/auth"
+	"github.com/altinity/altinity-dashboard/internal/metrics"
 	"github.com/altinity/altinity-dashboard/internal/utils"
 	chopv1 "github.com/altinity/clickhouse-operator/pkg/apis/clickhouse.altinity.com/v1"
 	"github.com/emicklei/go-restful/v3"
 	v1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	errors2 "k8s.io/apimachinery/pkg/api/errors"
+	"net/http"
+	"sync"
 )
 
 // DashboardResource is the REST layer to the dashboard
@@ -20,11 +23,11 @@ func (d *DashboardResource) Name() string {
 }
 
 // WebService creates a new service that can handle REST requests
-func (d *DashboardResource) WebService(_ *WebServiceInfo) (*restful.WebService, error) {
-	ws := new(restful.WebService)
+func (d *DashboardResource) WebService(wsi *WebServiceInfo) (*restful.WebService, error) {
+	ws := newWebService(wsi, "/dashboard")
 	ws.
-		Path("/api/v1/dashboard").
-		Produces(restful.MIME_JSON)
+		Produces(restful.MIME_JSON).
+		Filter(requireRole(auth.RoleViewer))
 
 	ws.Route(ws.GET("").To(d.getDashboard).
 		Doc("get dashboard information").
@@ -34,27 +37,79 @@ func (d *DashboardResource) WebService(_ *WebServiceInfo) (*restful.WebService,
 	return ws, nil
 }
 
-func (d *DashboardResource) getDashboard(_ *restful.Request, response *restful.Response) {
-	dash := Dashboard{}
+// getDashboard reports on the cluster named in the URL, or aggregates across all clusters if none is named
+func (d *DashboardResource) getDashboard(request *restful.Request, response *restful.Response) {
+	names := utils.ClusterNames()
+	cluster := request.PathParameter("cluster")
+	if cluster != "" {
+		names = []string{cluster}
+	}
 
-	k := utils.GetK8s()
+	// Query the clusters in parallel, so one slow cluster doesn't hold up the others
+	dash := Dashboard{
+		Clusters: make([]DashboardCluster, len(names)),
+	}
+	errs := make([]error, len(names))
+	var wg sync.WaitGroup
+	for i, name := range names {
+		wg.Add(1)
+		go func(i int, name string) {
+			defer wg.Done()
+			dash.Clusters[i], errs[i] = d.getClusterDashboard(request, name)
+		}(i, name)
+	}
+	wg.Wait()
+
+	for _, dc := range dash.Clusters {
+		dash.ChopCount += dc.ChopCount
+		dash.ChopCountAvailable += dc.ChopCountAvailable
+		dash.ChiCount += dc.ChiCount
+		dash.ChiCountComplete += dc.ChiCountComplete
+	}
+	if len(dash.Clusters) > 0 {
+		dash.KubeCluster = dash.Clusters[0].KubeCluster
+		dash.KubeVersion = dash.Clusters[0].KubeVersion
+		dash.AuthStatus = dash.Clusters[0].AuthStatus
+	}
+	if len(errs) == 1 && errs[0] != nil {
+		// If there is only one cluster, not being able to reach it at all is an error
+		webError(request, response, http.StatusInternalServerError, errs[0])
+		return
+	}
+	_ = response.WriteEntity(dash)
+}
+
+// getClusterDashboard gets the dashboard information for a single cluster.  The error is only returned if
+// no Kubernetes client could be obtained for the cluster; other errors are reported in the result.
+func (d *DashboardResource) getClusterDashboard(request *restful.Request, name string) (DashboardCluster, error) {
+	dash := DashboardCluster{Name: name}
+
+	k, err := getClusterK8s(request, name)
+	if err != nil {
+		dash.KubeVersion = "unknown"
+		dash.AuthStatus = "unknown"
+		dash.Error = err.Error()
+		return dash, err
+	}
 	defer func() { k.ReleaseK8s() }()
 	dash.KubeCluster = k.Config.Host
+	dash.Kubeconfig = k.KubeconfigStatus()
+	dash.Cache = k.Cache().Status()
 	sv, err := k.Clientset.ServerVersion()
 	if err == nil {
 		dash.KubeVersion = sv.String()
 	} else {
 		dash.KubeVersion = "unknown"
+		dash.Error = err.Error()
 	}
+	dash.AuthStatus = authStatus(err)
 
 	// Get clickhouse-operator counts
 	var chops *v1.DeploymentList
-	chops, err = k.Clientset.AppsV1().Deployments("").List(
-		context.TODO(), metav1.ListOptions{
-			LabelSelector: "app=clickhouse-operator",
-		})
+	chops, err = getK8sOperatorDeployments(k, "")
 	if err == nil {
 		dash.ChopCount = len(chops.Items)
+		metrics.Operators.WithLabelValues(name).Set(float64(dash.ChopCount))
 		dash.ChopCountAvailable = 0
 		for _, chop := range chops.Items {
 			for _, cond := range chop.Status.Conditions {
@@ -68,10 +123,10 @@ func (d *DashboardResource) getDashboard(_ *restful.Request, response *restful.R
 
 	// Get CHI counts
 	var chis *chopv1.ClickHouseInstallationList
-	chis, err = k.ChopClientset.ClickhouseV1().ClickHouseInstallations("").List(
-		context.TODO(), metav1.ListOptions{})
+	chis, err = getK8sCHIs(k, "", "")
 	if err == nil {
 		dash.ChiCount = len(chis.Items)
+		metrics.CHIs.WithLabelValues(name).Set(float64(dash.ChiCount))
 		dash.ChiCountComplete = 0
 		for _, chi := range chis.Items {
 			if chi.Status.Status == chopv1.StatusCompleted {
@@ -80,5 +135,19 @@ func (d *DashboardResource) getDashboard(_ *restful.Request, response *restful.R
 		}
 	}
 
-	_ = response.WriteEntity(dash)
+	return dash, nil
+}
+
+// authStatus describes whether a request was rejected because of the dashboard's credentials
+func authStatus(err error) string {
+	switch {
+	case err == nil:
+		return "ok"
+	case errors2.IsUnauthorized(err):
+		return "unauthorized"
+	case errors2.IsForbidden(err):
+		return "forbidden"
+	default:
+		return "unknown"
+	}
 }
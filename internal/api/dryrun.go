@@ -0,0 +1,71 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"github.com/emicklei/go-restful/v3"
+	errors2 "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"net/http"
+	"strconv"
+)
+
+var ErrDryRunInvalid = errors.New("dryRun must be true or false")
+
+// dryRunParameter is the name of the query parameter that asks for a change to be validated but not made
+const dryRunParameter = "dryRun"
+
+// dryRunParam documents the dryRun query parameter on a route
+func dryRunParam(ws *restful.WebService) *restful.Parameter {
+	return ws.QueryParameter(dryRunParameter,
+		"if true, the API server validates and admits the change without persisting it, "+
+			"and the response describes the result").DataType("boolean").DefaultValue("false")
+}
+
+// isDryRun reports whether the request asks for a dry run
+func isDryRun(request *restful.Request) (bool, error) {
+	q := request.QueryParameter(dryRunParameter)
+	if q == "" {
+		return false, nil
+	}
+	dryRun, err := strconv.ParseBool(q)
+	if err != nil {
+		return false, fmt.Errorf("%w: %s", ErrDryRunInvalid, q)
+	}
+	return dryRun, nil
+}
+
+// writeDryRunResult responds to a dry run with the resulting objects, or with the reasons the API server
+// rejected the change, using the status code the API server gave.  Errors that did not come from the API
+// server are reported as usual.
+func writeDryRunResult(request *restful.Request, response *restful.Response, objs []*unstructured.Unstructured, err error) {
+	if err != nil {
+		var se *errors2.StatusError
+		if !errors.As(err, &se) {
+			webError(request, response, http.StatusInternalServerError, err)
+			return
+		}
+		result := DryRunResult{Error: se.ErrStatus.Message}
+		if se.ErrStatus.Details != nil {
+			for _, cause := range se.ErrStatus.Details.Causes {
+				result.Causes = append(result.Causes, DryRunCause{
+					Field:   cause.Field,
+					Reason:  string(cause.Type),
+					Message: cause.Message,
+				})
+			}
+		}
+		status := int(se.ErrStatus.Code)
+		if status < http.StatusBadRequest {
+			status = http.StatusUnprocessableEntity
+		}
+		_ = response.WriteHeaderAndEntity(status, result)
+		return
+	}
+	result := DryRunResult{Valid: true, Objects: make([]map[string]interface{}, 0, len(objs))}
+	for _, obj := range objs {
+		obj.SetManagedFields(nil)
+		result.Objects = append(result.Objects, obj.Object)
+	}
+	_ = response.WriteEntity(result)
+}
@@ -2,7 +2,7 @@ package api
 
 import (
 	"context"
-	"github.com/altinity/altinity-dashboard/internal/utils"
+	"github.com/altinity/altinity-dashboard/internal/auth"
 	"github.com/emicklei/go-restful/v3"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -19,12 +19,13 @@ func (n *NamespaceResource) Name() string {
 }
 
 // WebService creates a new service that can handle REST requests
-func (n *NamespaceResource) WebService(_ *WebServiceInfo) (*restful.WebService, error) {
-	ws := new(restful.WebService)
+func (n *NamespaceResource) WebService(wsi *WebServiceInfo) (*restful.WebService, error) {
+	ws := newWebService(wsi, "/namespaces")
 	ws.
-		Path("/api/v1/namespaces").
 		Consumes(restful.MIME_JSON).
-		Produces(restful.MIME_JSON)
+		Produces(restful.MIME_JSON).
+		Filter(requireRole(auth.RoleViewer)).
+		Filter(auditMutations(wsi.Audit))
 
 	ws.Route(ws.GET("").To(n.getNamespaces).
 		Doc("get all namespaces").
@@ -32,18 +33,23 @@ func (n *NamespaceResource) WebService(_ *WebServiceInfo) (*restful.WebService,
 		Returns(200, "OK", []Namespace{}))
 
 	ws.Route(ws.PUT("").To(n.createNamespace).
+		Filter(requireRole(auth.RoleOperator)).
 		Doc("create a namespace").
 		Reads(Namespace{})) // from the request
 
 	return ws, nil
 }
 
-func (n *NamespaceResource) getNamespaces(_ *restful.Request, response *restful.Response) {
-	k := utils.GetK8s()
+func (n *NamespaceResource) getNamespaces(request *restful.Request, response *restful.Response) {
+	k, err := getK8s(request)
+	if err != nil {
+		webError(request, response, http.StatusInternalServerError, err)
+		return
+	}
 	defer func() { k.ReleaseK8s() }()
-	namespaces, err := k.Clientset.CoreV1().Namespaces().List(context.TODO(), metav1.ListOptions{})
+	namespaces, err := getK8sNamespaces(k)
 	if err != nil {
-		webError(response, http.StatusInternalServerError, err)
+		webError(request, response, http.StatusInternalServerError, err)
 		return
 	}
 	list := make([]Namespace, 0, len(namespaces.Items))
@@ -59,19 +65,24 @@ func (n *NamespaceResource) createNamespace(request *restful.Request, response *
 	namespace := new(Namespace)
 	err := request.ReadEntity(&namespace)
 	if err != nil {
-		webError(response, http.StatusBadRequest, err)
+		webError(request, response, http.StatusBadRequest, err)
 		return
 	}
+	setAuditTarget(request, namespace.Name)
 
 	// Check if the namespace already exists
-	k := utils.GetK8s()
+	k, err := getK8s(request)
+	if err != nil {
+		webError(request, response, http.StatusInternalServerError, err)
+		return
+	}
 	defer func() { k.ReleaseK8s() }()
 	namespaces, err := k.Clientset.CoreV1().Namespaces().List(context.TODO(), metav1.ListOptions{
 		FieldSelector: "metadata.name=" + namespace.Name,
 		Limit:         1,
 	})
 	if err != nil {
-		webError(response, http.StatusInternalServerError, err)
+		webError(request, response, http.StatusInternalServerError, err)
 		return
 	}
 	if len(namespaces.Items) > 0 {
@@ -89,7 +100,7 @@ func (n *NamespaceResource) createNamespace(request *restful.Request, response *
 		},
 		metav1.CreateOptions{})
 	if err != nil {
-		webError(response, http.StatusInternalServerError, err)
+		webError(request, response, http.StatusInternalServerError, err)
 		return
 	}
 	_ = response.WriteEntity(namespace)
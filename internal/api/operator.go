@@ -4,6 +4,8 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"github.com/altinity/altinity-dashboard/internal/auth"
+	"github.com/altinity/altinity-dashboard/internal/metrics"
 	"github.com/altinity/altinity-dashboard/internal/utils"
 	chopv1 "github.com/altinity/clickhouse-operator/pkg/apis/clickhouse.altinity.com/v1"
 	"github.com/emicklei/go-restful/v3"
@@ -12,7 +14,7 @@ import (
 	errors2 "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
-	"log"
+	"log/slog"
 	"net/http"
 	"strings"
 	"time"
@@ -37,6 +39,9 @@ func (o *OperatorResource) Name() string {
 // WebService creates a new service that can handle REST requests
 func (o *OperatorResource) WebService(wsi *WebServiceInfo) (*restful.WebService, error) {
 	o.chopRelease = wsi.ChopRelease
+	if wsi.OperatorVersion != "" {
+		o.chopRelease = wsi.OperatorVersion
+	}
 	err := utils.ReadFilesToStrings(wsi.Embed, []utils.FileToString{
 		{Filename: "embed/clickhouse-operator-install-template.yaml", Dest: &o.opDeployTemplate},
 	})
@@ -44,11 +49,12 @@ func (o *OperatorResource) WebService(wsi *WebServiceInfo) (*restful.WebService,
 		return nil, err
 	}
 
-	ws := new(restful.WebService)
+	ws := newWebService(wsi, "/operators")
 	ws.
-		Path("/api/v1/operators").
 		Consumes(restful.MIME_JSON).
-		Produces(restful.MIME_JSON)
+		Produces(restful.MIME_JSON).
+		Filter(requireRole(auth.RoleViewer)).
+		Filter(auditMutations(wsi.Audit))
 
 	ws.Route(ws.GET("").To(o.handleGetOps).
 		Doc("get all operators").
@@ -56,12 +62,16 @@ func (o *OperatorResource) WebService(wsi *WebServiceInfo) (*restful.WebService,
 		Returns(200, "OK", []Operator{}))
 
 	ws.Route(ws.PUT("/{namespace}").To(o.handlePutOp).
+		Filter(requireRole(auth.RoleAdmin)).
 		Doc("deploy or update an operator").
 		Param(ws.PathParameter("namespace", "namespace to deploy to").DataType("string")).
+		Param(dryRunParam(ws)).
 		Reads(OperatorPutParams{}).
-		Returns(200, "OK", Operator{}))
+		Returns(200, "OK", Operator{}).
+		Returns(422, "Rejected by the API server (dry run)", DryRunResult{}))
 
 	ws.Route(ws.DELETE("/{namespace}").To(o.handleDeleteOp).
+		Filter(requireRole(auth.RoleAdmin)).
 		Doc("delete an operator").
 		Param(ws.PathParameter("namespace", "namespace to delete from").DataType("string")).
 		Returns(200, "OK", nil))
@@ -69,8 +79,8 @@ func (o *OperatorResource) WebService(wsi *WebServiceInfo) (*restful.WebService,
 	return ws, nil
 }
 
-func (o *OperatorResource) getOperatorPodsFromDeployment(namespace string, deployment appsv1.Deployment) ([]OperatorPod, error) {
-	pods, err := getK8sPodsFromLabelSelector(namespace, deployment.Spec.Selector)
+func getOperatorPodsFromDeployment(k *utils.K8s, namespace string, deployment *appsv1.Deployment) ([]OperatorPod, error) {
+	pods, err := getK8sPodsFromLabelSelector(k, namespace, deployment.Spec.Selector)
 	if err != nil {
 		return nil, err
 	}
@@ -86,7 +96,7 @@ func (o *OperatorResource) getOperatorPodsFromDeployment(namespace string, deplo
 			}
 		}
 		var pod *Pod
-		pod, err = getPodFromK8sPod(&k8pod)
+		pod, err = getPodFromK8sPod(k, &k8pod)
 		if err != nil {
 			return nil, err
 		}
@@ -98,59 +108,72 @@ func (o *OperatorResource) getOperatorPodsFromDeployment(namespace string, deplo
 	return list, nil
 }
 
-func (o *OperatorResource) getOperators(namespace string) ([]Operator, error) {
-	k := utils.GetK8s()
-	defer func() { k.ReleaseK8s() }()
-	deployments, err := k.Clientset.AppsV1().Deployments(namespace).List(
-		context.TODO(), metav1.ListOptions{
-			LabelSelector: "app=clickhouse-operator",
-		})
+// getOperatorFromDeployment converts a clickhouse-operator deployment to its API model, looking up its pods
+func getOperatorFromDeployment(k *utils.K8s, deployment *appsv1.Deployment) (*Operator, error) {
+	conds := deployment.Status.Conditions
+	condStrs := make([]string, 0, len(conds))
+	for _, cond := range conds {
+		if cond.Status == corev1.ConditionTrue {
+			condStrs = append(condStrs, string(cond.Type))
+		}
+	}
+	var condStr string
+	if len(condStrs) > 0 {
+		condStr = strings.Join(condStrs, ", ")
+	} else {
+		condStr = "Unavailable"
+	}
+	l := deployment.Labels
+	ver, ok := l["version"]
+	if !ok {
+		ver, ok = l["clickhouse.altinity.com/chop"]
+		if !ok {
+			ver = "unknown"
+		}
+	}
+	pods, err := getOperatorPodsFromDeployment(k, deployment.Namespace, deployment)
+	if err != nil {
+		return nil, err
+	}
+	return &Operator{
+		Name:       deployment.Name,
+		Namespace:  deployment.Namespace,
+		Conditions: condStr,
+		Version:    ver,
+		Pods:       pods,
+	}, nil
+}
+
+func (o *OperatorResource) getOperators(k *utils.K8s, namespace string) ([]Operator, error) {
+	deployments, err := getK8sOperatorDeployments(k, namespace)
 	if err != nil {
 		return nil, err
 	}
+	if namespace == "" {
+		metrics.Operators.WithLabelValues(k.Name).Set(float64(len(deployments.Items)))
+	}
 	list := make([]Operator, 0, len(deployments.Items))
-	for _, deployment := range deployments.Items {
-		conds := deployment.Status.Conditions
-		condStrs := make([]string, 0, len(conds))
-		for _, cond := range conds {
-			if cond.Status == corev1.ConditionTrue {
-				condStrs = append(condStrs, string(cond.Type))
-			}
-		}
-		var condStr string
-		if len(condStrs) > 0 {
-			condStr = strings.Join(condStrs, ", ")
-		} else {
-			condStr = "Unavailable"
-		}
-		l := deployment.Labels
-		ver, ok := l["version"]
-		if !ok {
-			ver, ok = l["clickhouse.altinity.com/chop"]
-			if !ok {
-				ver = "unknown"
-			}
-		}
-		var pods []OperatorPod
-		pods, err = o.getOperatorPodsFromDeployment(deployment.Namespace, deployment)
+	for i := range deployments.Items {
+		var op *Operator
+		op, err = getOperatorFromDeployment(k, &deployments.Items[i])
 		if err != nil {
 			return nil, err
 		}
-		list = append(list, Operator{
-			Name:       deployment.Name,
-			Namespace:  deployment.Namespace,
-			Conditions: condStr,
-			Version:    ver,
-			Pods:       pods,
-		})
+		list = append(list, *op)
 	}
 	return list, nil
 }
 
-func (o *OperatorResource) handleGetOps(_ *restful.Request, response *restful.Response) {
-	ops, err := o.getOperators("")
+func (o *OperatorResource) handleGetOps(request *restful.Request, response *restful.Response) {
+	k, err := getK8s(request)
 	if err != nil {
-		webError(response, http.StatusInternalServerError, err)
+		webError(request, response, http.StatusInternalServerError, err)
+		return
+	}
+	defer func() { k.ReleaseK8s() }()
+	ops, err := o.getOperators(k, "")
+	if err != nil {
+		webError(request, response, http.StatusInternalServerError, err)
 		return
 	}
 	_ = response.WriteEntity(ops)
@@ -166,12 +189,12 @@ func processTemplate(template string, vars map[string]string) string {
 
 var ErrStillHaveCHIs = errors.New("cannot delete the last clickhouse-operator while CHI resources still exist")
 
-// deployOrDeleteOperator deploys or deletes a clickhouse-operator
-func (o *OperatorResource) deployOrDeleteOperator(namespace string, version string, doDelete bool) error {
+// deploymentYAML returns the YAML used to deploy a given version of clickhouse-operator to a namespace
+func (o *OperatorResource) deploymentYAML(namespace string, version string) string {
 	if version == "" {
 		version = o.chopRelease
 	}
-	deploy := processTemplate(o.opDeployTemplate, map[string]string{
+	return processTemplate(o.opDeployTemplate, map[string]string{
 		"OPERATOR_IMAGE":                     fmt.Sprintf("altinity/clickhouse-operator:%s", version),
 		"METRICS_EXPORTER_IMAGE":             fmt.Sprintf("altinity/metrics-exporter:%s", version),
 		"OPERATOR_NAMESPACE":                 namespace,
@@ -179,14 +202,18 @@ func (o *OperatorResource) deployOrDeleteOperator(namespace string, version stri
 		"OPERATOR_IMAGE_PULL_POLICY":         "Always",
 		"METRICS_EXPORTER_IMAGE_PULL_POLICY": "Always",
 	})
+}
+
+// deployOrDeleteOperator deploys or deletes a clickhouse-operator.  When deploying, it returns the applied
+// objects; if dryRun is set, they are validated but not persisted.
+func (o *OperatorResource) deployOrDeleteOperator(k *utils.K8s, namespace string, version string, doDelete bool,
+	dryRun bool) ([]*unstructured.Unstructured, error) {
+	deploy := o.deploymentYAML(namespace, version)
 
 	// Get existing operators
-	k := utils.GetK8s()
-	defer func() { k.ReleaseK8s() }()
-	var ops []Operator
-	ops, err := o.getOperators("")
+	ops, err := o.getOperators(k, "")
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	if doDelete {
@@ -199,55 +226,43 @@ func (o *OperatorResource) deployOrDeleteOperator(namespace string, version stri
 				var se *errors2.StatusError
 				if !errors.As(err, &se) || se.ErrStatus.Reason != metav1.StatusReasonNotFound ||
 					se.ErrStatus.Details.Group != "clickhouse.altinity.com" {
-					return err
+					return nil, err
 				}
 			}
 			if len(chis.Items) > 0 {
-				return ErrStillHaveCHIs
+				return nil, ErrStillHaveCHIs
 			}
 			// Delete cluster-wide resources (ie, CRDs) if we're really deleting the last operator
 			namespace = ""
 		}
-		err = k.MultiYamlDelete(deploy, namespace)
-		if err != nil {
-			return err
-		}
-	} else {
-		isUpgrade := false
-		for _, op := range ops {
-			if op.Namespace == namespace {
-				isUpgrade = true
-			}
+		return nil, k.MultiYamlDelete(deploy, namespace)
+	}
+	isUpgrade := false
+	for _, op := range ops {
+		if op.Namespace == namespace {
+			isUpgrade = true
 		}
-		if isUpgrade {
-			err = k.MultiYamlApplySelectively(deploy, namespace,
-				func(candidates []*unstructured.Unstructured) []*unstructured.Unstructured {
-					selected := make([]*unstructured.Unstructured, 0)
-					for _, c := range candidates {
-						if c.GetKind() == "Deployment" {
-							selected = append(selected, c)
-						}
+	}
+	if isUpgrade {
+		return k.MultiYamlApplySelectively(deploy, namespace,
+			func(candidates []*unstructured.Unstructured) []*unstructured.Unstructured {
+				selected := make([]*unstructured.Unstructured, 0)
+				for _, c := range candidates {
+					if c.GetKind() == "Deployment" {
+						selected = append(selected, c)
 					}
-					return selected
-				})
-			if err != nil {
-				return err
-			}
-		} else {
-			err = k.MultiYamlApply(deploy, namespace)
-			if err != nil {
-				return err
-			}
-		}
+				}
+				return selected
+			}, dryRun)
 	}
-	return nil
+	return k.MultiYamlApply(deploy, namespace, dryRun)
 }
 
 // waitForOperator waits for an operator to exist in the namespace
-func (o *OperatorResource) waitForOperator(namespace string, timeout time.Duration) (*Operator, error) {
+func (o *OperatorResource) waitForOperator(k *utils.K8s, namespace string, timeout time.Duration) (*Operator, error) {
 	startTime := time.Now()
 	for {
-		ops, err := o.getOperators(namespace)
+		ops, err := o.getOperators(k, namespace)
 		if err != nil {
 			return nil, err
 		}
@@ -264,31 +279,53 @@ func (o *OperatorResource) waitForOperator(namespace string, timeout time.Durati
 func (o *OperatorResource) handlePutOp(request *restful.Request, response *restful.Response) {
 	namespace := request.PathParameter("namespace")
 	if namespace == "" {
-		webError(response, http.StatusBadRequest, ErrNamespaceRequired)
+		webError(request, response, http.StatusBadRequest, ErrNamespaceRequired)
 		return
 	}
-	putParams := OperatorPutParams{}
-	err := request.ReadEntity(&putParams)
+	dryRun, err := isDryRun(request)
 	if err != nil {
-		webError(response, http.StatusBadRequest, err)
+		webError(request, response, http.StatusBadRequest, err)
 		return
 	}
-	err = o.deployOrDeleteOperator(namespace, putParams.Version, false)
+	putParams := OperatorPutParams{}
+	err = request.ReadEntity(&putParams)
 	if err != nil {
-		webError(response, http.StatusInternalServerError, err)
+		webError(request, response, http.StatusBadRequest, err)
 		return
 	}
-	op, err := o.waitForOperator(namespace, 15*time.Second)
+	k, err := getK8s(request)
 	if err != nil {
-		webError(response, http.StatusInternalServerError, err)
+		webError(request, response, http.StatusInternalServerError, err)
 		return
 	}
-	k := utils.GetK8s()
+	setAuditYAML(request, "", o.deploymentYAML(namespace, putParams.Version))
+	if dryRun {
+		var objs []*unstructured.Unstructured
+		objs, err = o.deployOrDeleteOperator(k, namespace, putParams.Version, false, true)
+		k.ReleaseK8s()
+		writeDryRunResult(request, response, objs, err)
+		return
+	}
+	var op *Operator
+	_, err = o.deployOrDeleteOperator(k, namespace, putParams.Version, false, false)
+	if err == nil {
+		op, err = o.waitForOperator(k, namespace, 15*time.Second)
+	}
 	k.ReleaseK8s()
-	err = k.Reinit()
 	if err != nil {
-		log.Printf("Error reinitializing the Kubernetes client: %s", err)
-		webError(response, http.StatusInternalServerError, err)
+		webError(request, response, http.StatusInternalServerError, err)
+		return
+	}
+	// Reinitialize the cluster's own instance, not just the caller's impersonating one, so that every
+	// instance learns about the new CRDs
+	root, err := utils.GetClusterK8s(request.PathParameter("cluster"))
+	if err == nil {
+		root.ReleaseK8s()
+		err = root.Reinit()
+	}
+	if err != nil {
+		slog.ErrorContext(request.Request.Context(), "Error reinitializing the Kubernetes client", slog.Any("err", err))
+		webError(request, response, http.StatusInternalServerError, err)
 		return
 	}
 	_ = response.WriteEntity(op)
@@ -297,12 +334,19 @@ func (o *OperatorResource) handlePutOp(request *restful.Request, response *restf
 func (o *OperatorResource) handleDeleteOp(request *restful.Request, response *restful.Response) {
 	namespace := request.PathParameter("namespace")
 	if namespace == "" {
-		webError(response, http.StatusBadRequest, ErrNamespaceRequired)
+		webError(request, response, http.StatusBadRequest, ErrNamespaceRequired)
 		return
 	}
-	err := o.deployOrDeleteOperator(namespace, "", true)
+	k, err := getK8s(request)
+	if err != nil {
+		webError(request, response, http.StatusInternalServerError, err)
+		return
+	}
+	defer func() { k.ReleaseK8s() }()
+	setAuditYAML(request, o.deploymentYAML(namespace, ""), "")
+	_, err = o.deployOrDeleteOperator(k, namespace, "", true, false)
 	if err != nil {
-		webError(response, http.StatusInternalServerError, err)
+		webError(request, response, http.StatusInternalServerError, err)
 		return
 	}
 	_ = response.WriteEntity(nil)
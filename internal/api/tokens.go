@@ -0,0 +1,110 @@
+package api
+
+import (
+	"errors"
+	"github.com/altinity/altinity-dashboard/internal/auth"
+	"github.com/emicklei/go-restful/v3"
+	"net/http"
+	"time"
+)
+
+// TokenResource is the REST layer to API tokens
+type TokenResource struct {
+	tokens *auth.Tokens
+}
+
+// TokenPostParams is the object for parameters to a token POST request
+type TokenPostParams struct {
+	Name    string    `json:"name" description:"name of the token"`
+	Scope   string    `json:"scope" description:"role granted to callers using the token (viewer, operator or admin)"`
+	Expires time.Time `json:"expires,omitempty" description:"time the token expires (never, if omitted)"`
+}
+
+// NewToken is a newly created API token, including its secret value
+type NewToken struct {
+	auth.APIToken
+	Token string `json:"token" description:"the token to send in the Authorization: Bearer header (only shown once)"`
+}
+
+// Name returns the name of the web service
+func (t *TokenResource) Name() string {
+	return "API Tokens"
+}
+
+// WebService creates a new service that can handle REST requests
+func (t *TokenResource) WebService(wsi *WebServiceInfo) (*restful.WebService, error) {
+	t.tokens = wsi.Tokens
+
+	ws := new(restful.WebService)
+	ws.
+		Path("/api/v1/tokens").
+		Consumes(restful.MIME_JSON).
+		Produces(restful.MIME_JSON).
+		Filter(requireRole(auth.RoleAdmin)).
+		Filter(auditMutations(wsi.Audit))
+
+	ws.Route(ws.GET("").To(t.getTokens).
+		Doc("get all API tokens").
+		Writes([]auth.APIToken{}).
+		Returns(200, "OK", []auth.APIToken{}))
+
+	ws.Route(ws.POST("").To(t.createToken).
+		Doc("create an API token").
+		Reads(TokenPostParams{}).
+		Returns(200, "OK", NewToken{}))
+
+	ws.Route(ws.DELETE("/{name}").To(t.deleteToken).
+		Doc("delete an API token").
+		Param(ws.PathParameter("name", "name of the token to delete").DataType("string")).
+		Returns(200, "OK", nil))
+
+	return ws, nil
+}
+
+func (t *TokenResource) getTokens(request *restful.Request, response *restful.Response) {
+	list, err := t.tokens.List()
+	if err != nil {
+		webError(request, response, http.StatusInternalServerError, err)
+		return
+	}
+	_ = response.WriteEntity(list)
+}
+
+func (t *TokenResource) createToken(request *restful.Request, response *restful.Response) {
+	params := TokenPostParams{}
+	err := request.ReadEntity(&params)
+	if err != nil {
+		webError(request, response, http.StatusBadRequest, err)
+		return
+	}
+	setAuditTarget(request, params.Name)
+	secret, tok, err := t.tokens.Create(params.Name, params.Scope, params.Expires)
+	switch {
+	case errors.Is(err, auth.ErrTokenNameInvalid), errors.Is(err, auth.ErrTokenScopeInvalid):
+		webError(request, response, http.StatusBadRequest, err)
+		return
+	case errors.Is(err, auth.ErrTokenExists):
+		webError(request, response, http.StatusConflict, err)
+		return
+	case err != nil:
+		webError(request, response, http.StatusInternalServerError, err)
+		return
+	}
+	_ = response.WriteEntity(NewToken{
+		APIToken: *tok,
+		Token:    secret,
+	})
+}
+
+func (t *TokenResource) deleteToken(request *restful.Request, response *restful.Response) {
+	err := t.tokens.Delete(request.PathParameter("name"))
+	switch {
+	case errors.Is(err, auth.ErrTokenNotFound):
+		webError(request, response, http.StatusNotFound, err)
+		return
+	case err != nil:
+		webError(request, response, http.StatusInternalServerError, err)
+		return
+	}
+	_ = response.WriteEntity(nil)
+}
@@ -0,0 +1,462 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/altinity/altinity-dashboard/internal/auth"
+	"github.com/altinity/altinity-dashboard/internal/utils"
+	chopv1 "github.com/altinity/clickhouse-operator/pkg/apis/clickhouse.altinity.com/v1"
+	"github.com/emicklei/go-restful/v3"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	errors2 "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// WatchResource is the REST layer to streams of changes to Kubernetes objects
+type WatchResource struct {
+	stopping <-chan struct{}
+}
+
+// WatchEvent is the data of a server-sent event in a watch stream
+type WatchEvent struct {
+	Kind      string      `json:"kind" description:"kind of object the event is about (chi, operator or pod)"`
+	Namespace string      `json:"namespace,omitempty" description:"namespace of the object"`
+	Name      string      `json:"name,omitempty" description:"name of the object"`
+	Object    interface{} `json:"object,omitempty" description:"the object, as returned by the other API routes, for added and modified events"`
+	Error     string      `json:"error,omitempty" description:"error watching the objects, for error events"`
+}
+
+// Kinds of object that can be watched
+const (
+	watchKindChi      = "chi"
+	watchKindOperator = "operator"
+	watchKindPod      = "pod"
+)
+
+var watchKinds = []string{watchKindChi, watchKindOperator, watchKindPod}
+
+// isWatchKind reports whether kind can be watched
+func isWatchKind(kind string) bool {
+	for _, k := range watchKinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// heartbeatInterval is how often a comment is sent on an idle stream, so proxies don't close it
+const heartbeatInterval = 15 * time.Second
+
+// watchRetryInterval is how long to wait before listing or watching again after an error.  It is also
+// the reconnection delay suggested to clients.
+const watchRetryInterval = 5 * time.Second
+
+var ErrUnknownWatchKind = errors.New("unknown kind to watch")
+var ErrStreamingUnsupported = errors.New("streaming is not supported by this connection")
+
+// Name returns the name of the web service
+func (w *WatchResource) Name() string {
+	return "Watch"
+}
+
+// WebService creates a new service that can handle REST requests
+func (w *WatchResource) WebService(wsi *WebServiceInfo) (*restful.WebService, error) {
+	w.stopping = wsi.Stopping
+	ws := newWebService(wsi, "/watch")
+	ws.
+		Produces("text/event-stream").
+		Filter(requireRole(auth.RoleViewer))
+
+	ws.Route(ws.GET("").To(w.handleWatch).
+		Doc("stream changes to CHIs, operators and pods as server-sent events").
+		Param(ws.QueryParameter("kinds", "comma-separated kinds to watch (chi, operator, pod); default is all").
+			DataType("string")).
+		Param(ws.QueryParameter("namespace", "namespace to watch; default is all").DataType("string")).
+		Param(ws.HeaderParameter("Last-Event-ID", "ID of the last event received, to resume a stream").
+			DataType("string")).
+		Writes(WatchEvent{}).
+		Returns(200, "OK", WatchEvent{}))
+
+	return ws, nil
+}
+
+// watchSource lists and watches the Kubernetes objects behind one kind, and converts them to API models
+type watchSource struct {
+	list  func(ctx context.Context, namespace string) ([]runtime.Object, string, error)
+	watch func(ctx context.Context, namespace string, resourceVersion string) (watch.Interface, error)
+	model func(k *utils.K8s, obj runtime.Object) (interface{}, error)
+}
+
+// watchSources returns the sources for each kind, using the clients of k.  The sources remain usable after
+// the caller releases k.
+func watchSources(k *utils.K8s) map[string]watchSource {
+	chis := k.ChopClientset.ClickhouseV1()
+	apps := k.Clientset.AppsV1()
+	core := k.Clientset.CoreV1()
+	operatorSelector := "app=clickhouse-operator"
+	podSelector := "clickhouse.altinity.com/chi"
+	return map[string]watchSource{
+		watchKindChi: {
+			list: func(ctx context.Context, namespace string) ([]runtime.Object, string, error) {
+				l, err := chis.ClickHouseInstallations(namespace).List(ctx, metav1.ListOptions{})
+				if err != nil {
+					return nil, "", err
+				}
+				objs := make([]runtime.Object, 0, len(l.Items))
+				for i := range l.Items {
+					objs = append(objs, &l.Items[i])
+				}
+				return objs, l.ResourceVersion, nil
+			},
+			watch: func(ctx context.Context, namespace string, resourceVersion string) (watch.Interface, error) {
+				return chis.ClickHouseInstallations(namespace).Watch(ctx, metav1.ListOptions{
+					ResourceVersion: resourceVersion,
+				})
+			},
+			model: func(k *utils.K8s, obj runtime.Object) (interface{}, error) {
+				return getChiFromK8sCHI(k, obj.(*chopv1.ClickHouseInstallation))
+			},
+		},
+		watchKindOperator: {
+			list: func(ctx context.Context, namespace string) ([]runtime.Object, string, error) {
+				l, err := apps.Deployments(namespace).List(ctx, metav1.ListOptions{LabelSelector: operatorSelector})
+				if err != nil {
+					return nil, "", err
+				}
+				objs := make([]runtime.Object, 0, len(l.Items))
+				for i := range l.Items {
+					objs = append(objs, &l.Items[i])
+				}
+				return objs, l.ResourceVersion, nil
+			},
+			watch: func(ctx context.Context, namespace string, resourceVersion string) (watch.Interface, error) {
+				return apps.Deployments(namespace).Watch(ctx, metav1.ListOptions{
+					LabelSelector:   operatorSelector,
+					ResourceVersion: resourceVersion,
+				})
+			},
+			model: func(k *utils.K8s, obj runtime.Object) (interface{}, error) {
+				return getOperatorFromDeployment(k, obj.(*appsv1.Deployment))
+			},
+		},
+		watchKindPod: {
+			list: func(ctx context.Context, namespace string) ([]runtime.Object, string, error) {
+				l, err := core.Pods(namespace).List(ctx, metav1.ListOptions{LabelSelector: podSelector})
+				if err != nil {
+					return nil, "", err
+				}
+				objs := make([]runtime.Object, 0, len(l.Items))
+				for i := range l.Items {
+					objs = append(objs, &l.Items[i])
+				}
+				return objs, l.ResourceVersion, nil
+			},
+			watch: func(ctx context.Context, namespace string, resourceVersion string) (watch.Interface, error) {
+				return core.Pods(namespace).Watch(ctx, metav1.ListOptions{
+					LabelSelector:   podSelector,
+					ResourceVersion: resourceVersion,
+				})
+			},
+			model: func(k *utils.K8s, obj runtime.Object) (interface{}, error) {
+				k8pod := obj.(*corev1.Pod)
+				pod, err := getPodFromK8sPod(k, k8pod)
+				if err != nil {
+					return nil, err
+				}
+				return &CHClusterPod{
+					Pod:         *pod,
+					ClusterName: k8pod.Labels["clickhouse.altinity.com/cluster"],
+				}, nil
+			},
+		},
+	}
+}
+
+// watchMessage is an event to be written to the stream
+type watchMessage struct {
+	event string
+	kind  string
+	// resourceVersion is the version the kind's watch can be resumed from after this event, if known
+	resourceVersion string
+	data            WatchEvent
+}
+
+// parseEventID parses the resource version of each kind from an event ID
+func parseEventID(id string) map[string]string {
+	versions := make(map[string]string)
+	for _, part := range strings.Split(id, ",") {
+		kv := strings.SplitN(part, ":", 2)
+		if len(kv) == 2 && kv[1] != "" {
+			versions[kv[0]] = kv[1]
+		}
+	}
+	return versions
+}
+
+// formatEventID encodes the resource version of each kind as an event ID
+func formatEventID(versions map[string]string) string {
+	parts := make([]string, 0, len(versions))
+	for kind, rv := range versions {
+		parts = append(parts, kind+":"+rv)
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ",")
+}
+
+func (w *WatchResource) handleWatch(request *restful.Request, response *restful.Response) {
+	kinds := watchKinds
+	if q := request.QueryParameter("kinds"); q != "" {
+		kinds = strings.Split(q, ",")
+		for _, kind := range kinds {
+			if !isWatchKind(kind) {
+				webError(request, response, http.StatusBadRequest, fmt.Errorf("%w: %s", ErrUnknownWatchKind, kind))
+				return
+			}
+		}
+	}
+	namespace := request.QueryParameter("namespace")
+	if _, ok := response.ResponseWriter.(http.Flusher); !ok {
+		webError(request, response, http.StatusInternalServerError, ErrStreamingUnsupported)
+		return
+	}
+
+	// Only hold the Kubernetes reference while creating the sources, since a stream can stay open indefinitely
+	cluster := request.PathParameter("cluster")
+	getK := func() (*utils.K8s, error) { return getClusterK8s(request, cluster) }
+	k, err := getK()
+	if err != nil {
+		webError(request, response, http.StatusInternalServerError, err)
+		return
+	}
+	sources := watchSources(k)
+	reinitialized := k.Reinitialized()
+	k.ReleaseK8s()
+
+	// End the stream when the client goes away or the server shuts down
+	ctx, cancel := context.WithCancel(request.Request.Context())
+	defer cancel()
+	go func() {
+		select {
+		case <-w.stopping:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	response.Header().Set("Content-Type", "text/event-stream")
+	response.Header().Set("Cache-Control", "no-cache")
+	response.Header().Set("X-Accel-Buffering", "no")
+	response.WriteHeader(http.StatusOK)
+	_, _ = fmt.Fprintf(response, "retry: %d\n\n", watchRetryInterval.Milliseconds())
+	response.Flush()
+
+	versions := parseEventID(request.HeaderParameter("Last-Event-ID"))
+	write := func(msg watchMessage) error {
+		var id string
+		if msg.resourceVersion != "" {
+			versions[msg.kind] = msg.resourceVersion
+			id = formatEventID(versions)
+		} else if msg.event == "reset" {
+			delete(versions, msg.kind)
+			id = formatEventID(versions)
+		}
+		data, err := json.Marshal(msg.data)
+		if err != nil {
+			return err
+		}
+		if id != "" {
+			_, err = fmt.Fprintf(response, "id: %s\n", id)
+		}
+		if err == nil {
+			_, err = fmt.Fprintf(response, "event: %s\ndata: %s\n\n", msg.event, data)
+		}
+		return err
+	}
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		// Each set of watches uses its own channel, so nothing from watches that have been stopped is sent
+		watchCtx, stopWatches := context.WithCancel(ctx)
+		messages := make(chan watchMessage)
+		for _, kind := range kinds {
+			go runWatch(watchCtx, kind, sources[kind], namespace, versions[kind], messages, getK)
+		}
+		restart, err := streamEvents(ctx, response, heartbeat.C, messages, reinitialized, write)
+		stopWatches()
+		if !restart || err != nil {
+			return
+		}
+
+		// The cluster was reinitialized, so the clients may now point at a different cluster or use different
+		// credentials.  Resource versions from the old watches can't be resumed from, so tell the client to
+		// discard what it has, and start again with the new clients.
+		for _, kind := range kinds {
+			err = write(watchMessage{event: "reset", kind: kind, data: WatchEvent{Kind: kind}})
+			if err != nil {
+				return
+			}
+		}
+		response.Flush()
+		k, err = getK()
+		if err != nil {
+			_ = write(watchMessage{event: "error", data: WatchEvent{Error: err.Error()}})
+			response.Flush()
+			return
+		}
+		sources = watchSources(k)
+		reinitialized = k.Reinitialized()
+		k.ReleaseK8s()
+	}
+}
+
+// streamEvents writes the messages and heartbeats to the stream until ctx is cancelled, writing fails, or
+// the cluster is reinitialized, in which case restart is true
+func streamEvents(ctx context.Context, response *restful.Response, heartbeat <-chan time.Time,
+	messages <-chan watchMessage, reinitialized <-chan struct{}, write func(watchMessage) error) (restart bool, err error) {
+	for {
+		select {
+		case <-ctx.Done():
+			return false, nil
+		case <-reinitialized:
+			return true, nil
+		case <-heartbeat:
+			_, err = fmt.Fprint(response, ": heartbeat\n\n")
+		case msg := <-messages:
+			err = write(msg)
+		}
+		if err != nil {
+			return false, err
+		}
+		response.Flush()
+	}
+}
+
+// runWatch sends the events for one kind until ctx is cancelled.  If resourceVersion is empty, or is too old
+// to resume from, the current objects are sent as added events, followed by a synced event.
+func runWatch(ctx context.Context, kind string, src watchSource, namespace string, resourceVersion string,
+	messages chan<- watchMessage, getK func() (*utils.K8s, error)) {
+	send := func(msg watchMessage) bool {
+		msg.kind = kind
+		msg.data.Kind = kind
+		select {
+		case messages <- msg:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+	sendError := func(err error) bool {
+		if !send(watchMessage{event: "error", data: WatchEvent{Error: err.Error()}}) {
+			return false
+		}
+		select {
+		case <-time.After(watchRetryInterval):
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+	sendObject := func(event string, obj runtime.Object, rv string) bool {
+		m, err := meta.Accessor(obj)
+		if err != nil {
+			return true
+		}
+		data := WatchEvent{Namespace: m.GetNamespace(), Name: m.GetName()}
+		if event != "deleted" {
+			var k *utils.K8s
+			k, err = getK()
+			if err == nil {
+				data.Object, err = src.model(k, obj)
+				k.ReleaseK8s()
+			}
+			if err != nil {
+				return send(watchMessage{event: "error", data: WatchEvent{Namespace: data.Namespace, Name: data.Name,
+					Error: err.Error()}})
+			}
+		}
+		return send(watchMessage{event: event, resourceVersion: rv, data: data})
+	}
+
+	for ctx.Err() == nil {
+		if resourceVersion == "" {
+			objs, rv, err := src.list(ctx, namespace)
+			if err != nil {
+				if !sendError(err) {
+					return
+				}
+				continue
+			}
+			for _, obj := range objs {
+				if !sendObject("added", obj, "") {
+					return
+				}
+			}
+			resourceVersion = rv
+			if !send(watchMessage{event: "synced", resourceVersion: rv}) {
+				return
+			}
+		}
+
+		wi, err := src.watch(ctx, namespace, resourceVersion)
+		if err != nil {
+			if errors2.IsResourceExpired(err) || errors2.IsGone(err) {
+				resourceVersion = ""
+				if !send(watchMessage{event: "reset"}) {
+					return
+				}
+				continue
+			}
+			if !sendError(err) {
+				return
+			}
+			continue
+		}
+		expired := false
+		for ev := range wi.ResultChan() {
+			switch ev.Type {
+			case watch.Added, watch.Modified, watch.Deleted:
+				rv := ""
+				if m, err := meta.Accessor(ev.Object); err == nil {
+					rv = m.GetResourceVersion()
+				}
+				if !sendObject(strings.ToLower(string(ev.Type)), ev.Object, rv) {
+					wi.Stop()
+					return
+				}
+				if rv != "" {
+					resourceVersion = rv
+				}
+			case watch.Error:
+				err = errors2.FromObject(ev.Object)
+				if errors2.IsResourceExpired(err) || errors2.IsGone(err) {
+					expired = true
+				} else if !send(watchMessage{event: "error", data: WatchEvent{Error: err.Error()}}) {
+					wi.Stop()
+					return
+				}
+			}
+			if expired {
+				break
+			}
+		}
+		wi.Stop()
+		if expired {
+			resourceVersion = ""
+			if !send(watchMessage{event: "reset"}) {
+				return
+			}
+		}
+	}
+}
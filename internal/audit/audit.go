@@ -0,0 +1,148 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// Event is a record of a single mutating action taken through the dashboard
+type Event struct {
+	Time         time.Time `json:"time" description:"time the action completed"`
+	RequestID    string    `json:"request_id,omitempty" description:"ID of the request, as used in the server logs"`
+	User         string    `json:"user" description:"user who took the action"`
+	SourceIP     string    `json:"source_ip" description:"IP address the request came from"`
+	Method       string    `json:"method" description:"HTTP method of the request"`
+	Route        string    `json:"route" description:"API route that handled the request"`
+	Path         string    `json:"path" description:"URL path of the request"`
+	Cluster      string    `json:"cluster,omitempty" description:"cluster named in the request URL, if any (otherwise the default cluster)"`
+	Namespace    string    `json:"namespace,omitempty" description:"namespace of the target object"`
+	Name         string    `json:"name,omitempty" description:"name of the target object"`
+	BeforeDigest string    `json:"before_digest,omitempty" description:"SHA-256 digest of the object's YAML before the action"`
+	AfterDigest  string    `json:"after_digest,omitempty" description:"SHA-256 digest of the object's YAML after the action"`
+	DryRun       bool      `json:"dry_run,omitempty" description:"whether the change was only validated, not made"`
+	Status       int       `json:"status" description:"HTTP status code of the response"`
+	Error        string    `json:"error,omitempty" description:"error message, if the action failed"`
+}
+
+// Query selects events from the audit log.  Zero-valued fields match all events.
+type Query struct {
+	Since   time.Time
+	Until   time.Time
+	User    string
+	Cluster string
+}
+
+func (q *Query) matches(e *Event) bool {
+	return (q.Since.IsZero() || !e.Time.Before(q.Since)) &&
+		(q.Until.IsZero() || e.Time.Before(q.Until)) &&
+		(q.User == "" || q.User == e.User) &&
+		(q.Cluster == "" || q.Cluster == e.Cluster)
+}
+
+// maxRecent is the number of events kept in memory when the log is not written to a file
+const maxRecent = 10000
+
+// Log writes audit events as JSON lines and answers queries about them
+type Log struct {
+	lock     sync.Mutex
+	out      io.Writer
+	filename string
+	recent   []Event
+}
+
+// NewLog creates an audit log.  If dest is a filename, events are appended to that file.  If dest is "-",
+// events are written to stdout.  If dest is empty, events are only kept in memory.
+func NewLog(dest string) (*Log, error) {
+	l := &Log{}
+	switch dest {
+	case "":
+	case "-":
+		l.out = os.Stdout
+	default:
+		f, err := os.OpenFile(dest, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			return nil, err
+		}
+		l.out = f
+		l.filename = dest
+	}
+	return l, nil
+}
+
+// Digest returns a short fingerprint of a YAML document, for recording object states
+func Digest(yaml string) string {
+	if yaml == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(yaml))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// Record adds an event to the log.  ctx is the context of the request the event is about.
+func (l *Log) Record(ctx context.Context, e *Event) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.out != nil {
+		data, err := json.Marshal(e)
+		if err == nil {
+			data = append(data, '\n')
+			_, err = l.out.Write(data)
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "Error writing audit log", slog.Any("err", err))
+		}
+	}
+	if l.filename == "" {
+		if len(l.recent) >= maxRecent {
+			l.recent = l.recent[1:]
+		}
+		l.recent = append(l.recent, *e)
+	}
+}
+
+// Query returns the events that match q, oldest first
+func (l *Log) Query(q *Query) ([]Event, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	events := make([]Event, 0)
+	if l.filename == "" {
+		for i := range l.recent {
+			if q.matches(&l.recent[i]) {
+				events = append(events, l.recent[i])
+			}
+		}
+		return events, nil
+	}
+	f, err := os.Open(l.filename)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var e Event
+		if json.Unmarshal(scanner.Bytes(), &e) != nil {
+			// Skip lines that aren't ours, such as a partial write from a crash
+			continue
+		}
+		if q.matches(&e) {
+			events = append(events, e)
+		}
+	}
+	err = scanner.Err()
+	if err != nil {
+		return nil, err
+	}
+	return events, nil
+}
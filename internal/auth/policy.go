@@ -0,0 +1,108 @@
+package auth
+
+import (
+	"fmt"
+	"os"
+	"sigs.k8s.io/yaml"
+)
+
+// Role is a level of access to the dashboard.  Each role includes the permissions of the roles below it.
+type Role int
+
+const (
+	RoleNone Role = iota
+	RoleViewer
+	RoleOperator
+	RoleAdmin
+)
+
+var roleNames = map[Role]string{
+	RoleNone:     "none",
+	RoleViewer:   "viewer",
+	RoleOperator: "operator",
+	RoleAdmin:    "admin",
+}
+
+func (r Role) String() string {
+	return roleNames[r]
+}
+
+var ErrUnknownRole = fmt.Errorf("unknown role")
+
+// ParseRole converts a role name to a Role
+func ParseRole(name string) (Role, error) {
+	for r, n := range roleNames {
+		if n == name {
+			return r, nil
+		}
+	}
+	return RoleNone, fmt.Errorf("%w: %q", ErrUnknownRole, name)
+}
+
+// policyFile is the on-disk format of a Policy
+type policyFile struct {
+	DefaultRole string            `json:"defaultRole"`
+	Users       map[string]string `json:"users"`
+	Groups      map[string]string `json:"groups"`
+}
+
+// Policy maps users and groups to roles
+type Policy struct {
+	defaultRole Role
+	users       map[string]Role
+	groups      map[string]Role
+}
+
+// LoadPolicy reads a Policy from a YAML file
+func LoadPolicy(filename string) (*Policy, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var pf policyFile
+	err = yaml.UnmarshalStrict(data, &pf)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %s: %w", filename, err)
+	}
+	p := &Policy{
+		users:  make(map[string]Role, len(pf.Users)),
+		groups: make(map[string]Role, len(pf.Groups)),
+	}
+	if pf.DefaultRole != "" {
+		p.defaultRole, err = ParseRole(pf.DefaultRole)
+		if err != nil {
+			return nil, fmt.Errorf("defaultRole: %w", err)
+		}
+	}
+	for name, role := range pf.Users {
+		p.users[name], err = ParseRole(role)
+		if err != nil {
+			return nil, fmt.Errorf("user %s: %w", name, err)
+		}
+	}
+	for name, role := range pf.Groups {
+		p.groups[name], err = ParseRole(role)
+		if err != nil {
+			return nil, fmt.Errorf("group %s: %w", name, err)
+		}
+	}
+	return p, nil
+}
+
+// RoleFor returns the highest role granted to a user, either directly or through one of their groups.
+// A nil Policy grants every user the admin role.
+func (p *Policy) RoleFor(u *User) Role {
+	if p == nil {
+		return RoleAdmin
+	}
+	role := p.defaultRole
+	if r, ok := p.users[u.Name]; ok && r > role {
+		role = r
+	}
+	for _, g := range u.Groups {
+		if r, ok := p.groups[g]; ok && r > role {
+			role = r
+		}
+	}
+	return role
+}
@@ -0,0 +1,123 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/altinity/altinity-dashboard/internal/utils"
+	corev1 "k8s.io/api/core/v1"
+	errors2 "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"os"
+	"path/filepath"
+)
+
+// FileTokenStore keeps API tokens in a local JSON file
+type FileTokenStore struct {
+	Filename string
+}
+
+// Load reads the tokens from the file.  A missing file means there are no tokens.
+func (f *FileTokenStore) Load() ([]APIToken, error) {
+	data, err := os.ReadFile(f.Filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return []APIToken{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var tokens []APIToken
+	err = json.Unmarshal(data, &tokens)
+	if err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// Save replaces the contents of the file with the given tokens
+func (f *FileTokenStore) Save(tokens []APIToken) error {
+	data, err := json.MarshalIndent(tokens, "", "  ")
+	if err != nil {
+		return err
+	}
+	// Write to a temporary file and rename it, so readers never see a partial file
+	tmp, err := os.CreateTemp(filepath.Dir(f.Filename), ".tokens-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.Remove(tmp.Name())
+	}()
+	_, err = tmp.Write(data)
+	if err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), f.Filename)
+}
+
+// SecretTokenStore keeps API tokens in a Kubernetes Secret
+type SecretTokenStore struct {
+	Namespace string
+	Name      string
+}
+
+const secretTokensKey = "tokens.json"
+
+// Load reads the tokens from the Secret.  A missing Secret means there are no tokens.
+func (s *SecretTokenStore) Load() ([]APIToken, error) {
+	k := utils.GetK8s()
+	defer func() { k.ReleaseK8s() }()
+	secret, err := k.Clientset.CoreV1().Secrets(s.Namespace).Get(context.TODO(), s.Name, metav1.GetOptions{})
+	if errors2.IsNotFound(err) {
+		return []APIToken{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var tokens []APIToken
+	data, ok := secret.Data[secretTokensKey]
+	if !ok {
+		return []APIToken{}, nil
+	}
+	err = json.Unmarshal(data, &tokens)
+	if err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// Save replaces the contents of the Secret with the given tokens, creating it if necessary
+func (s *SecretTokenStore) Save(tokens []APIToken) error {
+	data, err := json.Marshal(tokens)
+	if err != nil {
+		return err
+	}
+	k := utils.GetK8s()
+	defer func() { k.ReleaseK8s() }()
+	secrets := k.Clientset.CoreV1().Secrets(s.Namespace)
+	secret, err := secrets.Get(context.TODO(), s.Name, metav1.GetOptions{})
+	if errors2.IsNotFound(err) {
+		_, err = secrets.Create(context.TODO(), &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      s.Name,
+				Namespace: s.Namespace,
+			},
+			Data: map[string][]byte{secretTokensKey: data},
+		}, metav1.CreateOptions{})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	if secret.Data == nil {
+		secret.Data = make(map[string][]byte)
+	}
+	secret.Data[secretTokensKey] = data
+	_, err = secrets.Update(context.TODO(), secret, metav1.UpdateOptions{})
+	return err
+}
@@ -0,0 +1,198 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// APIToken is a named, long-lived credential for automation clients.  Only a hash of the token is stored.
+type APIToken struct {
+	Name    string    `json:"name" description:"name of the token"`
+	Scope   string    `json:"scope" description:"role granted to callers using the token (viewer, operator or admin)"`
+	Created time.Time `json:"created" description:"time the token was created"`
+	Expires time.Time `json:"expires,omitempty" description:"time the token expires (never, if omitted)"`
+	Hash    string    `json:"hash,omitempty" description:"SHA-256 hash of the token"`
+}
+
+// TokenStore persists API tokens
+type TokenStore interface {
+	Load() ([]APIToken, error)
+	Save([]APIToken) error
+}
+
+// Tokens manages the set of API tokens
+type Tokens struct {
+	store      TokenStore
+	lock       sync.Mutex
+	tokens     []APIToken
+	lastReload time.Time
+}
+
+// tokenPrefix marks a bearer token as one of ours, to make leaked tokens easier to identify
+const tokenPrefix = "adash_"
+
+// minReloadInterval limits how often an unknown token can cause the store to be reloaded
+const minReloadInterval = 5 * time.Second
+
+var ErrTokenNameInvalid = errors.New("token names must be 1-63 letters, digits, dots, dashes or underscores")
+var ErrTokenScopeInvalid = errors.New("token scope must be viewer, operator or admin")
+var ErrTokenExists = errors.New("a token with this name already exists")
+var ErrTokenNotFound = errors.New("token not found")
+var ErrTokenInvalid = errors.New("invalid or expired API token")
+
+var tokenNameRegexp = regexp.MustCompile(`^[A-Za-z0-9._-]{1,63}$`)
+
+// IsAPIToken reports whether a bearer token looks like one of our API tokens
+func IsAPIToken(secret string) bool {
+	return strings.HasPrefix(secret, tokenPrefix)
+}
+
+// NewTokens loads the API tokens from a store
+func NewTokens(store TokenStore) (*Tokens, error) {
+	tokens, err := store.Load()
+	if err != nil {
+		return nil, err
+	}
+	return &Tokens{
+		store:      store,
+		tokens:     tokens,
+		lastReload: time.Now(),
+	}, nil
+}
+
+func hashToken(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// List returns all tokens, without their hashes
+func (t *Tokens) List() ([]APIToken, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	tokens, err := t.store.Load()
+	if err != nil {
+		return nil, err
+	}
+	t.tokens = tokens
+	list := make([]APIToken, 0, len(tokens))
+	for _, tok := range tokens {
+		tok.Hash = ""
+		list = append(list, tok)
+	}
+	return list, nil
+}
+
+// Create makes a new token and returns its secret value, which cannot be retrieved again later
+func (t *Tokens) Create(name string, scope string, expires time.Time) (string, *APIToken, error) {
+	if !tokenNameRegexp.MatchString(name) {
+		return "", nil, ErrTokenNameInvalid
+	}
+	// A token with no role could authenticate but never be authorized to do anything
+	role, err := ParseRole(scope)
+	if err != nil || role == RoleNone {
+		return "", nil, fmt.Errorf("%w: %q", ErrTokenScopeInvalid, scope)
+	}
+	randBytes := make([]byte, 256/8)
+	_, err = rand.Read(randBytes)
+	if err != nil {
+		return "", nil, err
+	}
+	secret := tokenPrefix + base64.RawURLEncoding.EncodeToString(randBytes)
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	tokens, err := t.store.Load()
+	if err != nil {
+		return "", nil, err
+	}
+	for _, tok := range tokens {
+		if tok.Name == name {
+			return "", nil, ErrTokenExists
+		}
+	}
+	tok := APIToken{
+		Name:    name,
+		Scope:   scope,
+		Created: time.Now().UTC(),
+		Expires: expires,
+		Hash:    hashToken(secret),
+	}
+	tokens = append(tokens, tok)
+	err = t.store.Save(tokens)
+	if err != nil {
+		return "", nil, err
+	}
+	t.tokens = tokens
+	tok.Hash = ""
+	return secret, &tok, nil
+}
+
+// Delete removes a token
+func (t *Tokens) Delete(name string) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	tokens, err := t.store.Load()
+	if err != nil {
+		return err
+	}
+	for i, tok := range tokens {
+		if tok.Name == name {
+			tokens = append(tokens[:i], tokens[i+1:]...)
+			err = t.store.Save(tokens)
+			if err != nil {
+				return err
+			}
+			t.tokens = tokens
+			return nil
+		}
+	}
+	return ErrTokenNotFound
+}
+
+// find returns the token matching a secret, or nil
+func (t *Tokens) find(hash string) *APIToken {
+	for i := range t.tokens {
+		if subtle.ConstantTimeCompare([]byte(t.tokens[i].Hash), []byte(hash)) == 1 {
+			return &t.tokens[i]
+		}
+	}
+	return nil
+}
+
+// Authenticate returns the user identified by a bearer token
+func (t *Tokens) Authenticate(secret string) (*User, error) {
+	hash := hashToken(secret)
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	tok := t.find(hash)
+	if tok == nil && time.Since(t.lastReload) > minReloadInterval {
+		// The token may have been created by another replica
+		t.lastReload = time.Now()
+		tokens, err := t.store.Load()
+		if err != nil {
+			return nil, err
+		}
+		t.tokens = tokens
+		tok = t.find(hash)
+	}
+	if tok == nil || (!tok.Expires.IsZero() && time.Now().After(tok.Expires)) {
+		return nil, ErrTokenInvalid
+	}
+	role, err := ParseRole(tok.Scope)
+	if err != nil {
+		return nil, err
+	}
+	return &User{
+		Name: fmt.Sprintf("token:%s", tok.Name),
+		Role: role,
+	}, nil
+}
@@ -0,0 +1,45 @@
+package auth
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCreateTokenScope(t *testing.T) {
+	tests := []struct {
+		scope    string
+		wantErr  error
+		wantRole Role
+	}{
+		{scope: "viewer", wantRole: RoleViewer},
+		{scope: "operator", wantRole: RoleOperator},
+		{scope: "admin", wantRole: RoleAdmin},
+		{scope: "none", wantErr: ErrTokenScopeInvalid},
+		{scope: "", wantErr: ErrTokenScopeInvalid},
+		{scope: "root", wantErr: ErrTokenScopeInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.scope, func(t *testing.T) {
+			tokens, err := NewTokens(&FileTokenStore{Filename: filepath.Join(t.TempDir(), "tokens.json")})
+			if err != nil {
+				t.Fatal(err)
+			}
+			secret, _, err := tokens.Create("ci", tt.scope, time.Time{})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			user, err := tokens.Authenticate(secret)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if user.Role != tt.wantRole {
+				t.Errorf("got role %s, want %s", user.Role, tt.wantRole)
+			}
+		})
+	}
+}
@@ -0,0 +1,28 @@
+package auth
+
+import "context"
+
+// User is the identity of an authenticated caller
+type User struct {
+	Name   string   `json:"name"`
+	Groups []string `json:"groups,omitempty"`
+	Role   Role     `json:"-"`
+}
+
+type contextKey int
+
+const userKey contextKey = iota
+
+// WithUser returns a copy of ctx that carries the given user
+func WithUser(ctx context.Context, user *User) context.Context {
+	return context.WithValue(ctx, userKey, user)
+}
+
+// UserFromContext returns the user carried by ctx, or nil if there is none
+func UserFromContext(ctx context.Context) *User {
+	u, ok := ctx.Value(userKey).(*User)
+	if !ok {
+		return nil
+	}
+	return u
+}
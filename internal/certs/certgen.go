@@ -1,46 +1,198 @@
 package certs
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
 	"crypto/rand"
-	"crypto/rsa"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/pem"
-	"io/ioutil"
+	"errors"
 	"math/big"
+	"net"
 	"os"
+	"path/filepath"
+	"sync"
 	"time"
 )
 
+const caCertFile = "ca.crt"
+const caKeyFile = "ca.key"
+const caLifetime = 10 * 365 * 24 * time.Hour
+const leafLifetime = 365 * 24 * time.Hour
+
+var ErrInvalidCA = errors.New("CA certificate and key do not match or are not a CA")
+
 var filesToDelete []string
+var filesToDeleteLock sync.Mutex
+
+// CA is a local certificate authority used to sign self-signed server certificates.  It is kept on
+// disk so that users only need to trust it once.
+type CA struct {
+	Cert    *x509.Certificate
+	CertPEM []byte
+	key     *ecdsa.PrivateKey
+}
+
+// DefaultCADir returns the directory the CA is kept in if none is configured
+func DefaultCADir() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "altinity-dashboard", "ca"), nil
+}
+
+func randomSerial() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 127))
+}
+
+// LoadOrCreateCA loads the CA from a directory, creating a new one if it doesn't exist or has expired
+func LoadOrCreateCA(dir string) (*CA, error) {
+	ca, err := loadCA(dir)
+	if err == nil && time.Now().Before(ca.Cert.NotAfter) {
+		return ca, nil
+	}
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	return createCA(dir)
+}
+
+func loadCA(dir string) (*CA, error) {
+	certPEM, err := os.ReadFile(filepath.Join(dir, caCertFile))
+	if err != nil {
+		return nil, err
+	}
+	keyPEM, err := os.ReadFile(filepath.Join(dir, caKeyFile))
+	if err != nil {
+		return nil, err
+	}
+	certBlock, _ := pem.Decode(certPEM)
+	keyBlock, _ := pem.Decode(keyPEM)
+	if certBlock == nil || keyBlock == nil {
+		return nil, ErrInvalidCA
+	}
+	cert, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParseECPrivateKey(keyBlock.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	if !cert.IsCA || !key.PublicKey.Equal(cert.PublicKey) {
+		return nil, ErrInvalidCA
+	}
+	return &CA{
+		Cert:    cert,
+		CertPEM: certPEM,
+		key:     key,
+	}, nil
+}
+
+func createCA(dir string) (*CA, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			Organization: []string{"Altinity Dashboard"},
+			CommonName:   "Altinity Dashboard Local CA",
+		},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(caLifetime),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	certBytes, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(certBytes)
+	if err != nil {
+		return nil, err
+	}
+	keyBytes, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certBytes})
+
+	err = os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+	err = os.WriteFile(filepath.Join(dir, caKeyFile),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyBytes}), 0600)
+	if err != nil {
+		return nil, err
+	}
+	err = os.WriteFile(filepath.Join(dir, caCertFile), certPEM, 0644) //nolint:gosec
+	if err != nil {
+		return nil, err
+	}
+	return &CA{
+		Cert:    cert,
+		CertPEM: certPEM,
+		key:     key,
+	}, nil
+}
 
-// GenerateSelfSignedCerts generates self-signed certs, and optionally deletes them on program exit
-func GenerateSelfSignedCerts(removeOnExit bool) (certFileName string, keyFileName string, err error) {
+// GenerateServerCert generates a server certificate signed by the CA, valid for the given host names and
+// IP addresses, and optionally deletes it when RemoveGeneratedFiles is called
+func (ca *CA) GenerateServerCert(hosts []string, removeOnExit bool) (certFileName string, keyFileName string, err error) {
 	// Generate private key
-	privKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	privKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
 	if err != nil {
 		return "", "", err
 	}
 
 	// Generate certificate
+	serial, err := randomSerial()
+	if err != nil {
+		return "", "", err
+	}
 	template := &x509.Certificate{
-		SerialNumber: big.NewInt(1),
+		SerialNumber: serial,
 		Subject: pkix.Name{
 			Organization: []string{"Self-Signed"},
 		},
-		NotBefore:             time.Now(),
-		NotAfter:              time.Now().Add(time.Hour * 24 * 365),
-		KeyUsage:              x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(leafLifetime),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
 		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
 		BasicConstraintsValid: true,
 	}
-	certBytes, err := x509.CreateCertificate(rand.Reader, template, template, &privKey.PublicKey, privKey)
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else if h != "" {
+			template.DNSNames = append(template.DNSNames, h)
+		}
+	}
+	if len(template.DNSNames) > 0 {
+		template.Subject.CommonName = template.DNSNames[0]
+	}
+	certBytes, err := x509.CreateCertificate(rand.Reader, template, ca.Cert, &privKey.PublicKey, ca.key)
+	if err != nil {
+		return "", "", err
+	}
+	keyBytes, err := x509.MarshalECPrivateKey(privKey)
 	if err != nil {
 		return "", "", err
 	}
 
 	// Write private key to file
-	keyFile, err := ioutil.TempFile("", "self-signed-*.key")
+	keyFile, err := os.CreateTemp("", "self-signed-*.key")
 	if err != nil {
 		return "", "", err
 	}
@@ -49,8 +201,8 @@ func GenerateSelfSignedCerts(removeOnExit bool) (certFileName string, keyFileNam
 		return "", "", err
 	}
 	err = pem.Encode(keyFile, &pem.Block{
-		Type:  "RSA PRIVATE KEY",
-		Bytes: x509.MarshalPKCS1PrivateKey(privKey),
+		Type:  "EC PRIVATE KEY",
+		Bytes: keyBytes,
 	})
 	if err != nil {
 		return "", "", err
@@ -60,8 +212,8 @@ func GenerateSelfSignedCerts(removeOnExit bool) (certFileName string, keyFileNam
 		return "", "", err
 	}
 
-	// Write certificate to file
-	certFile, err := ioutil.TempFile("", "self-signed-*.crt")
+	// Write certificate to file, followed by the CA certificate so clients get the whole chain
+	certFile, err := os.CreateTemp("", "self-signed-*.crt")
 	if err != nil {
 		return "", "", err
 	}
@@ -72,23 +224,30 @@ func GenerateSelfSignedCerts(removeOnExit bool) (certFileName string, keyFileNam
 	if err != nil {
 		return "", "", err
 	}
+	_, err = certFile.Write(ca.CertPEM)
+	if err != nil {
+		return "", "", err
+	}
 	err = certFile.Close()
 	if err != nil {
 		return "", "", err
 	}
 
 	if removeOnExit {
-		filesToDelete = append(filesToDelete, keyFile.Name())
-		filesToDelete = append(filesToDelete, certFile.Name())
+		filesToDeleteLock.Lock()
+		filesToDelete = append(filesToDelete, keyFile.Name(), certFile.Name())
+		filesToDeleteLock.Unlock()
 	}
 
 	return certFile.Name(), keyFile.Name(), nil
 }
 
-func init() {
-	defer func() {
-		for _, fn := range filesToDelete {
-			_ = os.Remove(fn)
-		}
-	}()
+// RemoveGeneratedFiles deletes the generated certificates that were marked for removal on exit
+func RemoveGeneratedFiles() {
+	filesToDeleteLock.Lock()
+	defer filesToDeleteLock.Unlock()
+	for _, fn := range filesToDelete {
+		_ = os.Remove(fn)
+	}
+	filesToDelete = nil
 }
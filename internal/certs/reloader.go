@@ -0,0 +1,122 @@
+package certs
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// Reloader serves a TLS certificate and key from files, reloading them when they change
+type Reloader struct {
+	certFile  string
+	keyFile   string
+	lock      sync.RWMutex
+	cert      *tls.Certificate
+	certPEM   []byte
+	keyPEM    []byte
+	loaded    time.Time
+	lastError error
+}
+
+// ReloaderStatus describes the certificate being served and the result of the last reload attempt
+type ReloaderStatus struct {
+	Loaded   time.Time `json:"loaded" description:"time the certificate was last loaded"`
+	NotAfter time.Time `json:"not_after" description:"expiry time of the certificate"`
+	Error    string    `json:"error,omitempty" description:"error from the last reload attempt, if it failed"`
+}
+
+// NewReloader loads a certificate and key, failing if they are not valid
+func NewReloader(certFile string, keyFile string) (*Reloader, error) {
+	r := &Reloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+	}
+	_, err := r.reload()
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// reload reads the files and, if they have changed, replaces the certificate being served.  It reports
+// whether the certificate was replaced.
+func (r *Reloader) reload() (bool, error) {
+	certPEM, err := os.ReadFile(r.certFile)
+	if err != nil {
+		return false, err
+	}
+	keyPEM, err := os.ReadFile(r.keyFile)
+	if err != nil {
+		return false, err
+	}
+	r.lock.RLock()
+	unchanged := bytes.Equal(certPEM, r.certPEM) && bytes.Equal(keyPEM, r.keyPEM)
+	r.lock.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return false, err
+	}
+	cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return false, err
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.cert = &cert
+	r.certPEM = certPEM
+	r.keyPEM = keyPEM
+	r.loaded = time.Now()
+	return true, nil
+}
+
+// Watch checks the files for changes at the given interval until ctx is cancelled.  Failed reloads are
+// logged, and the previous certificate continues to be served.
+func (r *Reloader) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		changed, err := r.reload()
+		r.lock.Lock()
+		r.lastError = err
+		r.lock.Unlock()
+		if err != nil {
+			slog.Error("Error reloading TLS certificate", slog.String("file", r.certFile), slog.Any("err", err))
+		} else if changed {
+			slog.Info("Reloaded TLS certificate", slog.String("file", r.certFile))
+		}
+	}
+}
+
+// GetCertificate returns the current certificate, for use in tls.Config
+func (r *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.cert, nil
+}
+
+// Status returns the state of the certificate being served
+func (r *Reloader) Status() ReloaderStatus {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	rs := ReloaderStatus{
+		Loaded:   r.loaded,
+		NotAfter: r.cert.Leaf.NotAfter,
+	}
+	if r.lastError != nil {
+		rs.Error = r.lastError.Error()
+	}
+	return rs
+}
@@ -0,0 +1,154 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sigs.k8s.io/yaml"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// EnvPrefix is prepended to the upper-cased flag name to give the environment variable for a setting
+const EnvPrefix = "ADASH_"
+
+var ErrUnknownKey = errors.New("unknown setting")
+var ErrInvalidValue = errors.New("value must be a string, number, boolean or list")
+var ErrDuplicateKey = errors.New("keys set the same setting")
+
+// Apply fills in settings that were not given on the command line, first from ADASH_* environment
+// variables and then from a YAML config file, if one is given.  Command-line flags therefore take
+// precedence over environment variables, which take precedence over the config file.  Settings are
+// named after their flags.  In the config file, nested keys are joined, so that tls: {cert: ...} sets
+// -tlscert.  Errors name the offending setting.
+func Apply(fs *flag.FlagSet, configFile string, exclude ...string) error {
+	excluded := make(map[string]bool)
+	for _, e := range exclude {
+		excluded[e] = true
+	}
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+
+	// Environment variables
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if err != nil || set[f.Name] || excluded[f.Name] {
+			return
+		}
+		envName := EnvPrefix + strings.ToUpper(f.Name)
+		if v, ok := os.LookupEnv(envName); ok {
+			if serr := fs.Set(f.Name, v); serr != nil {
+				err = fmt.Errorf("environment variable %s: %w", envName, serr)
+				return
+			}
+			set[f.Name] = true
+		}
+	})
+	if err != nil || configFile == "" {
+		return err
+	}
+
+	// Config file
+	data, err := os.ReadFile(configFile)
+	if err != nil {
+		return err
+	}
+	var doc map[string]interface{}
+	err = yaml.Unmarshal(data, &doc)
+	if err != nil {
+		return fmt.Errorf("config file %s: %w", configFile, err)
+	}
+	settings := make(map[string]setting)
+	err = flatten(doc, "", "", settings)
+	if err != nil {
+		return fmt.Errorf("config file %s: %w", configFile, err)
+	}
+	names := make([]string, 0, len(settings))
+	for name := range settings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		s := settings[name]
+		if fs.Lookup(name) == nil || excluded[name] {
+			return fmt.Errorf("config file %s: key %s: %w", configFile, s.key, ErrUnknownKey)
+		}
+		if set[name] {
+			continue
+		}
+		err = fs.Set(name, s.value)
+		if err != nil {
+			return fmt.Errorf("config file %s: key %s: %w", configFile, s.key, err)
+		}
+	}
+	return nil
+}
+
+// setting is a value from the config file, along with the key it was given as
+type setting struct {
+	key   string
+	value string
+}
+
+// flatten converts nested config file keys into flag names.  Keys that flatten to the same name, such as
+// tls: {cert: ...} and tlscert, are rejected, rather than one of them silently winning.
+func flatten(doc map[string]interface{}, prefix string, keyPrefix string, settings map[string]setting) error {
+	keys := make([]string, 0, len(doc))
+	for k := range doc {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v := doc[k]
+		name := prefix + strings.ToLower(k)
+		key := keyPrefix + k
+		switch val := v.(type) {
+		case map[string]interface{}:
+			err := flatten(val, name, key+".", settings)
+			if err != nil {
+				return err
+			}
+		default:
+			value, err := stringValue(val)
+			if err != nil {
+				return fmt.Errorf("key %s: %w", key, err)
+			}
+			if prev, ok := settings[name]; ok {
+				return fmt.Errorf("keys %s and %s: %w", prev.key, key, ErrDuplicateKey)
+			}
+			settings[name] = setting{key: key, value: value}
+		}
+	}
+	return nil
+}
+
+// stringValue converts a config file value to the string form used by flags.  Lists become
+// comma-separated strings.
+func stringValue(v interface{}) (string, error) {
+	switch val := v.(type) {
+	case string:
+		return val, nil
+	case bool:
+		return strconv.FormatBool(val), nil
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64), nil
+	case nil:
+		return "", nil
+	case []interface{}:
+		items := make([]string, 0, len(val))
+		for _, item := range val {
+			s, err := stringValue(item)
+			if err != nil {
+				return "", err
+			}
+			items = append(items, s)
+		}
+		return strings.Join(items, ","), nil
+	default:
+		return "", ErrInvalidValue
+	}
+}
@@ -0,0 +1,88 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestApply(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		env     map[string]string
+		file    string
+		want    map[string]string
+		wantErr error
+	}{
+		{
+			name: "nested keys",
+			file: "tls: {cert: a.crt, key: a.key}\nnamespace: ns\n",
+			want: map[string]string{"tlscert": "a.crt", "tlskey": "a.key", "namespace": "ns"},
+		},
+		{
+			name: "precedence",
+			args: []string{"-tlscert", "flag.crt"},
+			env:  map[string]string{"ADASH_TLSCERT": "env.crt", "ADASH_TLSKEY": "env.key"},
+			file: "tlscert: file.crt\ntlskey: file.key\nnamespace: file\n",
+			want: map[string]string{"tlscert": "flag.crt", "tlskey": "env.key", "namespace": "file"},
+		},
+		{
+			name:    "nested and flat keys for the same setting",
+			file:    "tls: {cert: a.crt}\ntlscert: b.crt\n",
+			wantErr: ErrDuplicateKey,
+		},
+		{
+			name:    "keys differing only in case",
+			file:    "TLSCert: a.crt\ntlscert: b.crt\n",
+			wantErr: ErrDuplicateKey,
+		},
+		{
+			name:    "unknown key",
+			file:    "tls: {chain: a.crt}\n",
+			wantErr: ErrUnknownKey,
+		},
+		{
+			name:    "excluded key",
+			file:    "config: other.yaml\n",
+			wantErr: ErrUnknownKey,
+		},
+		{
+			name:    "map in a list",
+			file:    "namespace: [{a: b}]\n",
+			wantErr: ErrInvalidValue,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+			values := map[string]*string{
+				"config":    fs.String("config", "", ""),
+				"tlscert":   fs.String("tlscert", "", ""),
+				"tlskey":    fs.String("tlskey", "", ""),
+				"namespace": fs.String("namespace", "", ""),
+			}
+			if err := fs.Parse(tt.args); err != nil {
+				t.Fatal(err)
+			}
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			configFile := filepath.Join(t.TempDir(), "config.yaml")
+			if err := os.WriteFile(configFile, []byte(tt.file), 0600); err != nil {
+				t.Fatal(err)
+			}
+			err := Apply(fs, configFile, "config")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			for name, want := range tt.want {
+				if got := *values[name]; got != want {
+					t.Errorf("%s: got %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
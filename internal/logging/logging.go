@@ -0,0 +1,177 @@
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// RequestIDHeader is the header a request ID is read from, if the client or a proxy provides one, and
+// returned in on every response
+const RequestIDHeader = "X-Request-ID"
+
+var ErrUnknownLogLevel = errors.New("log level must be debug, info, warn or error")
+var ErrUnknownLogFormat = errors.New("log format must be text or json")
+
+var requestIDRegexp = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// Setup configures the default logger.  This also applies to messages logged with the standard log package.
+func Setup(level string, format string) error {
+	var l slog.Level
+	switch strings.ToLower(level) {
+	case "debug":
+		l = slog.LevelDebug
+	case "", "info":
+		l = slog.LevelInfo
+	case "warn", "warning":
+		l = slog.LevelWarn
+	case "error":
+		l = slog.LevelError
+	default:
+		return ErrUnknownLogLevel
+	}
+	opts := &slog.HandlerOptions{Level: l}
+	var h slog.Handler
+	switch strings.ToLower(format) {
+	case "", "text", "logfmt":
+		h = slog.NewTextHandler(os.Stderr, opts)
+	case "json":
+		h = slog.NewJSONHandler(os.Stderr, opts)
+	default:
+		return ErrUnknownLogFormat
+	}
+	slog.SetDefault(slog.New(contextHandler{h}))
+	return nil
+}
+
+// contextHandler adds the ID and user of the request a message is logged for, taken from the context
+// passed to slog's *Context functions, to each message
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if ri := RequestInfoFromContext(ctx); ri != nil {
+		r.AddAttrs(slog.String("request_id", ri.ID))
+		if ri.User != "" {
+			r.AddAttrs(slog.String("user", ri.User))
+		}
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+// RequestInfo holds the details of a request that are included in its log messages
+type RequestInfo struct {
+	ID   string
+	User string
+}
+
+type requestInfoKey struct{}
+
+// WithRequestInfo returns a copy of ctx carrying the request info
+func WithRequestInfo(ctx context.Context, ri *RequestInfo) context.Context {
+	return context.WithValue(ctx, requestInfoKey{}, ri)
+}
+
+// RequestInfoFromContext returns the request info carried by ctx, or nil if there is none
+func RequestInfoFromContext(ctx context.Context) *RequestInfo {
+	ri, _ := ctx.Value(requestInfoKey{}).(*RequestInfo)
+	return ri
+}
+
+// RequestID returns the ID of the request ctx belongs to, or "" if there is none
+func RequestID(ctx context.Context) string {
+	if ri := RequestInfoFromContext(ctx); ri != nil {
+		return ri.ID
+	}
+	return ""
+}
+
+// SetUser records the user making a request, so it can be included in the access log
+func SetUser(ctx context.Context, user string) {
+	if ri := RequestInfoFromContext(ctx); ri != nil {
+		ri.User = user
+	}
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// statusRecorder captures the status code written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	if s.status == 0 {
+		s.status = status
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	return s.ResponseWriter.Write(b)
+}
+
+// Flush passes flushes through to the underlying writer, so streaming responses still work
+func (s *statusRecorder) Flush() {
+	if f, ok := s.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap gives http.ResponseController access to the underlying writer
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// Middleware assigns each request an ID, returns it in the response headers, and writes an access log
+// entry once the request has been served
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ri := &RequestInfo{
+			ID: r.Header.Get(RequestIDHeader),
+		}
+		if !requestIDRegexp.MatchString(ri.ID) {
+			ri.ID = newRequestID()
+		}
+		w.Header().Set(RequestIDHeader, ri.ID)
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r.WithContext(WithRequestInfo(r.Context(), ri)))
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		slog.LogAttrs(r.Context(), slog.LevelInfo, "request",
+			slog.String("request_id", ri.ID),
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", rec.status),
+			slog.Duration("duration", time.Since(start)),
+			slog.String("user", ri.User),
+			slog.String("remote_addr", r.RemoteAddr),
+		)
+	})
+}
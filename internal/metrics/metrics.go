@@ -0,0 +1,127 @@
+package metrics
+
+import (
+	"context"
+	"github.com/emicklei/go-restful/v3"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	k8smetrics "k8s.io/client-go/tools/metrics"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const namespace = "adash"
+
+var registry = prometheus.NewRegistry()
+
+// Requests counts API requests by route, method and status code
+var Requests = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "http_requests_total",
+	Help:      "Number of API requests, by route, method and status code.",
+}, []string{"route", "method", "code"})
+
+// RequestDuration observes API request latencies by route and method
+var RequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: namespace,
+	Name:      "http_request_duration_seconds",
+	Help:      "Latency of API requests, by route and method.",
+	Buckets:   prometheus.DefBuckets,
+}, []string{"route", "method"})
+
+// K8sRequestDuration observes Kubernetes client request latencies by verb and host
+var K8sRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: namespace,
+	Name:      "kubernetes_request_duration_seconds",
+	Help:      "Latency of requests to the Kubernetes API server, by verb and host.",
+	Buckets:   prometheus.DefBuckets,
+}, []string{"verb", "host"})
+
+// K8sRequests counts Kubernetes client requests by status code, method and host
+var K8sRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "kubernetes_requests_total",
+	Help:      "Number of requests to the Kubernetes API server, by status code, method and host.",
+}, []string{"code", "method", "host"})
+
+// Reinits counts reinitializations of the Kubernetes client
+var Reinits = prometheus.NewCounter(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "kubernetes_client_reinits_total",
+	Help:      "Number of times the Kubernetes client has been reinitialized.",
+})
+
+// Errors counts errors returned by the API, by type
+var Errors = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "api_errors_total",
+	Help:      "Number of errors returned by the API, by type.",
+}, []string{"type"})
+
+// CHIs is the number of ClickHouse Installations seen in the most recent cluster-wide listing, by cluster
+var CHIs = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: namespace,
+	Name:      "clickhouse_installations",
+	Help:      "Number of ClickHouse Installations seen in the most recent cluster-wide listing, by cluster.",
+}, []string{"cluster"})
+
+// Operators is the number of clickhouse-operator deployments seen in the most recent cluster-wide listing, by cluster
+var Operators = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: namespace,
+	Name:      "clickhouse_operators",
+	Help:      "Number of clickhouse-operator deployments seen in the most recent cluster-wide listing, by cluster.",
+}, []string{"cluster"})
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		Requests,
+		RequestDuration,
+		K8sRequestDuration,
+		K8sRequests,
+		Reinits,
+		Errors,
+		CHIs,
+		Operators,
+	)
+	k8smetrics.Register(k8smetrics.RegisterOpts{
+		RequestLatency: k8sLatency{},
+		RequestResult:  k8sResult{},
+	})
+}
+
+// Handler serves the metrics in the Prometheus exposition format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+// Filter is a go-restful container filter that records the count and latency of each request
+func Filter(request *restful.Request, response *restful.Response, chain *restful.FilterChain) {
+	start := time.Now()
+	chain.ProcessFilter(request, response)
+	route := request.SelectedRoutePath()
+	if route == "" {
+		route = "unmatched"
+	}
+	method := request.Request.Method
+	Requests.WithLabelValues(route, method, strconv.Itoa(response.StatusCode())).Inc()
+	RequestDuration.WithLabelValues(route, method).Observe(time.Since(start).Seconds())
+}
+
+// k8sLatency adapts K8sRequestDuration to client-go's metrics interface
+type k8sLatency struct{}
+
+func (k8sLatency) Observe(_ context.Context, verb string, u url.URL, latency time.Duration) {
+	K8sRequestDuration.WithLabelValues(verb, u.Host).Observe(latency.Seconds())
+}
+
+// k8sResult adapts K8sRequests to client-go's metrics interface
+type k8sResult struct{}
+
+func (k8sResult) Increment(_ context.Context, code string, method string, host string) {
+	K8sRequests.WithLabelValues(code, method, host).Inc()
+}
@@ -1,43 +1,370 @@
 package server
 
-import "net/http"
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"github.com/altinity/altinity-dashboard/internal/auth"
+	"github.com/altinity/altinity-dashboard/internal/logging"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AuthConfig holds the settings for the authentication handler
+type AuthConfig struct {
+	// NoToken disables authentication, treating every caller as an anonymous user
+	NoToken bool
+	// AuthToken is the one-time login token users must present, if OIDC is not in use
+	AuthToken string
+	// OIDC is the OpenID Connect login configuration, or nil to use AuthToken
+	OIDC *OIDCConfig
+	// Policy maps authenticated users to roles.  If nil, all users are admins.
+	Policy *auth.Policy
+	// Tokens are the API tokens accepted in an Authorization: Bearer header, or nil to not accept any
+	Tokens *auth.Tokens
+	// TokenReview validates other bearer tokens with Kubernetes, and authorizes their requests with
+	// SubjectAccessReviews
+	TokenReview bool
+	// ClientCerts authenticates callers who present a verified TLS client certificate
+	ClientCerts bool
+	// BasePath is the URL path prefix the dashboard is served under, used to scope cookies and redirects
+	BasePath string
+	// SessionIdleTimeout ends sessions that have not been used for this long (0 for no limit)
+	SessionIdleTimeout time.Duration
+	// SessionMaxLifetime ends sessions this long after they started (0 for no limit)
+	SessionMaxLifetime time.Duration
+	IsHTTPS            bool
+}
 
 type Handler struct {
+	noToken     bool
+	clientCerts bool
+	tokenLock   sync.Mutex
 	authToken   string
 	isHTTPS     bool
+	basePath    string
+	oidc        *oidcAuth
+	signer      *cookieSigner
+	csrf        *cookieSigner
+	sessions    *sessionStore
+	policy      *auth.Policy
+	tokens      *auth.Tokens
+	reviewer    *tokenReviewer
 	origHandler http.Handler
 }
 
+const logoutPath = "/logout"
+
+var ErrNoLoginToken = errors.New("login tokens are not in use")
+
+// tokenUser is the identity given to callers who authenticate with the startup token
+var tokenUser = auth.User{Name: "token"}
+
+// anonymousUser is the identity given to all callers when authentication is disabled
+var anonymousUser = auth.User{Name: "anonymous"}
+
+// newLoginToken generates a random login token
+func newLoginToken() (string, error) {
+	randBytes := make([]byte, 256/8)
+	_, err := rand.Read(randBytes)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(randBytes), nil
+}
+
+// unauthorized rejects a request that is not authenticated
+func unauthorized(w http.ResponseWriter) {
+	w.WriteHeader(401)
+	_, _ = w.Write([]byte("Unauthorized"))
+}
+
+// bearerToken returns the token from a request's Authorization header, if it has one
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return "", false
+	}
+	return token, true
+}
+
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if bearer, ok := bearerToken(r); ok {
+		switch {
+		case h.tokens != nil && (h.reviewer == nil || auth.IsAPIToken(bearer)):
+			user, err := h.tokens.Authenticate(bearer)
+			if err != nil {
+				unauthorized(w)
+				return
+			}
+			h.serveWithRole(w, r, user)
+			return
+		case h.reviewer != nil:
+			h.serveTokenReview(w, r, bearer)
+			return
+		}
+	}
+	if h.clientCerts && r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		// Browsers send client certificates automatically, so these requests need CSRF protection too
+		user := userFromClientCert(r.TLS.VerifiedChains[0][0])
+		if user == nil {
+			unauthorized(w)
+			return
+		}
+		h.serveSession(w, r, user, "cert\x00"+user.Name)
+		return
+	}
+	if h.noToken {
+		h.serveSession(w, r, &anonymousUser, "")
+		return
+	}
+	if r.URL.Path == logoutPath && h.sessions != nil {
+		h.logout(w, r)
+		return
+	}
+	if h.oidc != nil {
+		h.serveOIDC(w, r)
+		return
+	}
+	if h.sessions == nil {
+		unauthorized(w)
+		return
+	}
 	q := r.URL.Query()
 	tokReq := q.Get("token")
 	if tokReq != "" {
+		// Exchange the login token for a session, unless the browser already has one
+		if user, _ := h.sessionUser(r); user == nil {
+			if !h.consumeLoginToken(tokReq) {
+				unauthorized(w)
+				return
+			}
+			err := h.startSession(w, &tokenUser)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+		u := *r.URL
+		u.Path = h.basePath + u.Path
+		u.RawPath = ""
+		q.Del("token")
+		u.RawQuery = q.Encode()
+		http.Redirect(w, r, u.String(), http.StatusFound)
+		return
+	}
+	user, sessionID := h.sessionUser(r)
+	if user == nil {
+		unauthorized(w)
+		return
+	}
+	h.serveSession(w, r, user, sessionID)
+}
+
+// serveAs assigns a role to an authenticated user and passes the request on with the user attached
+func (h *Handler) serveAs(w http.ResponseWriter, r *http.Request, user *auth.User) {
+	u := *user
+	u.Role = h.policy.RoleFor(&u)
+	h.serveWithRole(w, r, &u)
+}
+
+// serveSession checks the CSRF token of a request authenticated by a browser session, and passes it on
+// with the session's CSRF token attached so the UI can be given it
+func (h *Handler) serveSession(w http.ResponseWriter, r *http.Request, user *auth.User, sessionID string) {
+	token := csrfToken(h.csrf, sessionID)
+	if !checkCSRF(r, token) {
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write([]byte("Missing or invalid CSRF token"))
+		return
+	}
+	h.serveAs(w, r.WithContext(withCSRFToken(r.Context(), token)), user)
+}
+
+// serveWithRole passes the request on with a user, whose role has already been assigned, attached
+func (h *Handler) serveWithRole(w http.ResponseWriter, r *http.Request, user *auth.User) {
+	logging.SetUser(r.Context(), user.Name)
+	h.origHandler.ServeHTTP(w, r.WithContext(auth.WithUser(r.Context(), user)))
+}
+
+// consumeLoginToken checks a login token, invalidating it if it is correct so it can't be used again
+func (h *Handler) consumeLoginToken(token string) bool {
+	h.tokenLock.Lock()
+	defer h.tokenLock.Unlock()
+	if h.authToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(h.authToken)) != 1 {
+		return false
+	}
+	h.authToken = ""
+	return true
+}
+
+// RotateLoginToken replaces the login token with a new one and returns it.  Existing sessions
+// are not affected.
+func (h *Handler) RotateLoginToken() (string, error) {
+	if h.sessions == nil || h.oidc != nil {
+		return "", ErrNoLoginToken
+	}
+	token, err := newLoginToken()
+	if err != nil {
+		return "", err
+	}
+	h.tokenLock.Lock()
+	defer h.tokenLock.Unlock()
+	h.authToken = token
+	return token, nil
+}
+
+// startSession creates a session for a user and sends the browser its cookie
+func (h *Handler) startSession(w http.ResponseWriter, user *auth.User) error {
+	id, err := h.sessions.create(user)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    id,
+		Path:     h.basePath + "/",
+		Secure:   h.isHTTPS,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+// sessionUser returns the user and ID of the session named in the request's cookie, or nil if there is none
+func (h *Handler) sessionUser(r *http.Request) (*auth.User, string) {
+	c, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return nil, ""
+	}
+	user := h.sessions.get(c.Value)
+	if user == nil {
+		return nil, ""
+	}
+	return user, c.Value
+}
+
+// logout ends the session named in the request's cookie.  It must be a POST carrying the session's CSRF
+// token, so that other sites can't log the user out.
+func (h *Handler) logout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if _, sessionID := h.sessionUser(r); sessionID != "" {
+		if !hasCSRFToken(r, csrfToken(h.csrf, sessionID)) {
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte("Missing or invalid CSRF token"))
+			return
+		}
+		h.sessions.delete(sessionID)
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Path:     h.basePath + "/",
+		MaxAge:   -1,
+		Secure:   h.isHTTPS,
+		HttpOnly: true,
+	})
+	_, _ = w.Write([]byte("Logged out"))
+}
+
+// serveTokenReview authenticates and authorizes a request, using Kubernetes to check its bearer token
+func (h *Handler) serveTokenReview(w http.ResponseWriter, r *http.Request, bearer string) {
+	user, err := h.reviewer.authenticate(r.Context(), bearer)
+	if err != nil {
+		if !errors.Is(err, ErrTokenReviewRejected) {
+			slog.ErrorContext(r.Context(), "TokenReview failed", slog.Any("err", err))
+		}
+		unauthorized(w)
+		return
+	}
+	allowed, err := h.reviewer.authorize(r.Context(), user, r)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "SubjectAccessReview failed", slog.Any("err", err))
+	}
+	if !allowed {
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write([]byte("Forbidden"))
+		return
+	}
+	h.serveAs(w, r, user)
+}
+
+// serveOIDC authenticates a request using the session cookie, starting an OIDC login if there is none
+func (h *Handler) serveOIDC(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == oidcCallbackPath {
+		user, returnTo, err := h.oidc.finishLogin(r, h.signer)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "OIDC login failed", slog.Any("err", err))
+			unauthorized(w)
+			return
+		}
 		http.SetCookie(w, &http.Cookie{
-			Name:     "token",
-			Value:    tokReq,
+			Name:     oidcStateCookie,
+			Path:     h.basePath + oidcCallbackPath,
+			MaxAge:   -1,
 			Secure:   h.isHTTPS,
 			HttpOnly: true,
-			SameSite: http.SameSiteStrictMode,
 		})
-		u := r.URL
-		q.Del("token")
-		u.RawQuery = q.Encode()
-		http.Redirect(w, r, u.String(), http.StatusFound)
+		err = h.startSession(w, user)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, r, returnTo, http.StatusFound)
+		return
+	}
+	user, sessionID := h.sessionUser(r)
+	if user != nil {
+		h.serveSession(w, r, user, sessionID)
 		return
 	}
-	c, err := r.Cookie("token")
-	if err != nil || c.Value != h.authToken {
-		w.WriteHeader(401)
-		_, _ = w.Write([]byte("Unauthorized"))
+	// API calls can't follow a login redirect, so just reject them
+	if r.Method != http.MethodGet || strings.HasPrefix(r.URL.Path, "/api/") {
+		unauthorized(w)
 		return
 	}
-	h.origHandler.ServeHTTP(w, r)
+	h.oidc.startLogin(w, r, h.signer, h.isHTTPS)
 }
 
-func NewHandler(origHandler http.Handler, authToken string, isHTTPS bool) http.Handler {
-	return &Handler{
-		authToken:   authToken,
-		isHTTPS:     isHTTPS,
+// NewHandler creates a Handler that authenticates requests before passing them to origHandler
+func NewHandler(origHandler http.Handler, ac *AuthConfig) (*Handler, error) {
+	h := &Handler{
+		noToken:     ac.NoToken,
+		clientCerts: ac.ClientCerts,
+		authToken:   ac.AuthToken,
+		isHTTPS:     ac.IsHTTPS,
+		basePath:    ac.BasePath,
+		policy:      ac.Policy,
+		tokens:      ac.Tokens,
 		origHandler: origHandler,
 	}
+	if ac.TokenReview {
+		h.reviewer = newTokenReviewer()
+	}
+	var err error
+	h.csrf, err = newCookieSigner()
+	if err != nil {
+		return nil, err
+	}
+	if ac.AuthToken != "" || ac.OIDC != nil {
+		h.sessions = newSessionStore(ac.SessionIdleTimeout, ac.SessionMaxLifetime)
+	}
+	if ac.OIDC != nil {
+		h.oidc, err = newOIDCAuth(context.Background(), ac.OIDC, ac.BasePath)
+		if err != nil {
+			return nil, err
+		}
+		h.signer, err = newCookieSigner()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return h, nil
 }
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestLogout(t *testing.T) {
+	h, err := NewHandler(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}), &AuthConfig{
+		AuthToken: "login",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/?token=login", nil))
+	sess := cookieNamed(rec.Result(), sessionCookie)
+	if sess == nil {
+		t.Fatal("login did not start a session")
+	}
+	loggedIn := func() bool {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.AddCookie(sess)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code == http.StatusOK
+	}
+
+	tests := []struct {
+		name         string
+		method       string
+		csrf         string
+		wantStatus   int
+		wantLoggedIn bool
+	}{
+		{name: "GET", method: http.MethodGet, csrf: csrfToken(h.csrf, sess.Value),
+			wantStatus: http.StatusMethodNotAllowed, wantLoggedIn: true},
+		{name: "POST without CSRF token", method: http.MethodPost,
+			wantStatus: http.StatusForbidden, wantLoggedIn: true},
+		{name: "POST with another session's CSRF token", method: http.MethodPost, csrf: csrfToken(h.csrf, "other"),
+			wantStatus: http.StatusForbidden, wantLoggedIn: true},
+		{name: "POST with CSRF token", method: http.MethodPost, csrf: csrfToken(h.csrf, sess.Value),
+			wantStatus: http.StatusOK, wantLoggedIn: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, logoutPath, nil)
+			req.AddCookie(sess)
+			if tt.csrf != "" {
+				req.Header.Set(csrfHeader, tt.csrf)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Errorf("got status %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if got := loggedIn(); got != tt.wantLoggedIn {
+				t.Errorf("logged in after logout: %v, want %v", got, tt.wantLoggedIn)
+			}
+		})
+	}
+}
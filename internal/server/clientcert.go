@@ -0,0 +1,59 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"github.com/altinity/altinity-dashboard/internal/auth"
+	"os"
+)
+
+var ErrNoClientCACerts = errors.New("no certificates found in client CA file")
+var ErrUnknownClientAuthMode = errors.New("client certificate mode must be optional or require")
+
+// clientAuthType converts a client certificate mode name to a tls.ClientAuthType
+func clientAuthType(mode string) (tls.ClientAuthType, error) {
+	switch mode {
+	case "", "optional":
+		return tls.VerifyClientCertIfGiven, nil
+	case "require":
+		return tls.RequireAndVerifyClientCert, nil
+	default:
+		return tls.NoClientCert, ErrUnknownClientAuthMode
+	}
+}
+
+// loadClientCAs reads the CA certificates that client certificates must be signed by
+func loadClientCAs(filename string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, ErrNoClientCACerts
+	}
+	return pool, nil
+}
+
+// userFromClientCert returns the user identified by a verified client certificate.  The username is
+// the subject's common name or, if there isn't one, the first email, DNS or URI SAN.  The subject's
+// organizations are the user's groups.
+func userFromClientCert(cert *x509.Certificate) *auth.User {
+	name := cert.Subject.CommonName
+	switch {
+	case name != "":
+	case len(cert.EmailAddresses) > 0:
+		name = cert.EmailAddresses[0]
+	case len(cert.DNSNames) > 0:
+		name = cert.DNSNames[0]
+	case len(cert.URIs) > 0:
+		name = cert.URIs[0].String()
+	default:
+		return nil
+	}
+	return &auth.User{
+		Name:   name,
+		Groups: cert.Subject.Organization,
+	}
+}
@@ -0,0 +1,43 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"github.com/altinity/altinity-dashboard/internal/utils"
+	"regexp"
+	"strings"
+)
+
+var ErrClusterSpecInvalid = errors.New("clusters must be given as name=kubeconfig, name=#context or name=kubeconfig#context")
+var ErrClusterNameInvalid = errors.New("cluster names may only contain lower case letters, digits and dashes")
+
+var clusterNameRegexp = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+
+// parseClusterSpecs parses a comma-separated list of clusters, each given as name=kubeconfig#context.  Either
+// the kubeconfig or the context may be omitted.  Clusters without a kubeconfig use defaultKubeconfig.
+func parseClusterSpecs(s string, defaultKubeconfig string) ([]utils.ClusterSpec, error) {
+	var specs []utils.ClusterSpec
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, rest, ok := strings.Cut(entry, "=")
+		if !ok || rest == "" {
+			return nil, fmt.Errorf("%w: %s", ErrClusterSpecInvalid, entry)
+		}
+		if !clusterNameRegexp.MatchString(name) {
+			return nil, fmt.Errorf("%w: %s", ErrClusterNameInvalid, name)
+		}
+		kubeconfig, context, _ := strings.Cut(rest, "#")
+		if kubeconfig == "" {
+			kubeconfig = defaultKubeconfig
+		}
+		specs = append(specs, utils.ClusterSpec{
+			Name:       name,
+			Kubeconfig: kubeconfig,
+			Context:    context,
+		})
+	}
+	return specs, nil
+}
@@ -0,0 +1,154 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/altinity/altinity-dashboard/internal/certs"
+	"github.com/altinity/altinity-dashboard/internal/utils"
+	chopv1 "github.com/altinity/clickhouse-operator/pkg/apis/clickhouse.altinity.com/v1"
+	errors2 "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"net/http"
+	"time"
+)
+
+const healthzPath = "/healthz"
+const readyzPath = "/readyz"
+const versionPath = "/version"
+const metricsPath = "/metrics"
+
+// readyzTimeout limits how long the readiness checks can take
+const readyzTimeout = 5 * time.Second
+
+var ErrCRDsNotInstalled = errors.New("the ClickHouseInstallation CRD is not installed")
+var ErrTLSCertExpired = errors.New("the TLS certificate has expired")
+
+// Health is the response of the health endpoint
+type Health struct {
+	Status string                `json:"status" description:"always ok"`
+	TLS    *certs.ReloaderStatus `json:"tls,omitempty" description:"state of the TLS certificate, including any reload error, if serving TLS"`
+}
+
+// Readiness is the response of the readiness endpoint
+type Readiness struct {
+	Status string            `json:"status" description:"ok, or failing if any check failed"`
+	Checks map[string]string `json:"checks" description:"result of each check, either ok or an error message"`
+}
+
+// Version is the response of the version endpoint
+type Version struct {
+	Version     string `json:"version" description:"version of the dashboard"`
+	ChopRelease string `json:"chop_release" description:"version of clickhouse-operator the dashboard was built with"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// serveHealthz reports that the server is alive, along with the state of the TLS certificate.  It always
+// returns 200, since a failed certificate reload leaves the previous certificate in use and a restart would
+// not help; /readyz reports the failure instead.
+func (c *Config) serveHealthz(w http.ResponseWriter, _ *http.Request) {
+	h := Health{Status: "ok"}
+	if c.tlsReloader != nil {
+		ts := c.tlsReloader.Status()
+		h.TLS = &ts
+	}
+	writeJSON(w, http.StatusOK, h)
+}
+
+// serveReadyz reports whether the server is ready to serve users.  Individual checks can be skipped
+// with ?exclude=name, for example to report ready before clickhouse-operator has been deployed.
+func (c *Config) serveReadyz(w http.ResponseWriter, r *http.Request) {
+	excluded := make(map[string]bool)
+	for _, e := range r.URL.Query()["exclude"] {
+		excluded[e] = true
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), readyzTimeout)
+	defer cancel()
+	checks := []struct {
+		name  string
+		check func(context.Context) error
+	}{
+		{"kubernetes", checkKubernetes},
+		{"crds", checkCRDs},
+		{"tls", c.checkTLS},
+	}
+	rd := Readiness{
+		Status: "ok",
+		Checks: make(map[string]string),
+	}
+	for _, ch := range checks {
+		if excluded[ch.name] {
+			continue
+		}
+		err := ch.check(ctx)
+		if err != nil {
+			rd.Status = "failing"
+			rd.Checks[ch.name] = err.Error()
+		} else {
+			rd.Checks[ch.name] = "ok"
+		}
+	}
+	status := http.StatusOK
+	if rd.Status != "ok" {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, rd)
+}
+
+// serveVersion reports the version of the dashboard
+func (c *Config) serveVersion(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, Version{
+		Version:     c.AppVersion,
+		ChopRelease: c.ChopRelease,
+	})
+}
+
+// checkKubernetes checks that the Kubernetes API server is reachable
+func checkKubernetes(ctx context.Context) error {
+	k := utils.GetK8s()
+	defer func() { k.ReleaseK8s() }()
+	return k.Clientset.Discovery().RESTClient().Get().AbsPath("/version").Do(ctx).Error()
+}
+
+// checkCRDs checks that the ClickHouseInstallation CRD is installed
+func checkCRDs(ctx context.Context) error {
+	k := utils.GetK8s()
+	defer func() { k.ReleaseK8s() }()
+	var resources metav1.APIResourceList
+	err := k.Clientset.Discovery().RESTClient().Get().
+		AbsPath("/apis", chopv1.SchemeGroupVersion.Group, chopv1.SchemeGroupVersion.Version).
+		Do(ctx).Into(&resources)
+	if err != nil {
+		if errors2.IsNotFound(err) {
+			return ErrCRDsNotInstalled
+		}
+		return err
+	}
+	for _, res := range resources.APIResources {
+		if res.Name == "clickhouseinstallations" {
+			return nil
+		}
+	}
+	return ErrCRDsNotInstalled
+}
+
+// checkTLS checks that the TLS certificate being served is current and was last reloaded successfully
+func (c *Config) checkTLS(_ context.Context) error {
+	if c.tlsReloader == nil {
+		return nil
+	}
+	ts := c.tlsReloader.Status()
+	if ts.Error != "" {
+		//nolint:goerr113
+		return errors.New(ts.Error)
+	}
+	if time.Now().After(ts.NotAfter) {
+		return ErrTLSCertExpired
+	}
+	return nil
+}
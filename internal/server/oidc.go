@@ -0,0 +1,197 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/altinity/altinity-dashboard/internal/auth"
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const oidcCallbackPath = "/auth/callback"
+const oidcStateCookie = "oidc_state"
+const sessionCookie = "session"
+
+var ErrOIDCStateMismatch = errors.New("OIDC state does not match")
+var ErrOIDCNoIDToken = errors.New("OIDC token response did not include an ID token")
+var ErrOIDCNonceMismatch = errors.New("OIDC ID token nonce does not match")
+var ErrOIDCNoUsername = errors.New("OIDC ID token does not identify the user")
+
+// OIDCConfig holds the settings used to log in against an OpenID Connect provider
+type OIDCConfig struct {
+	Issuer        string
+	ClientID      string
+	ClientSecret  string
+	Scopes        []string
+	RedirectURL   string
+	UsernameClaim string
+	GroupsClaim   string
+}
+
+// oidcAuth runs the authorization code flow (with PKCE) against an OpenID Connect provider
+type oidcAuth struct {
+	oauth2Config  oauth2.Config
+	verifier      *oidc.IDTokenVerifier
+	usernameClaim string
+	groupsClaim   string
+	basePath      string
+}
+
+// oidcState is the data kept in a short-lived cookie while the browser is visiting the provider
+type oidcState struct {
+	State    string `json:"s"`
+	Verifier string `json:"v"`
+	Nonce    string `json:"n"`
+	ReturnTo string `json:"r"`
+}
+
+// newOIDCAuth discovers the provider's endpoints and prepares the login flow
+func newOIDCAuth(ctx context.Context, oc *OIDCConfig, basePath string) (*oidcAuth, error) {
+	provider, err := oidc.NewProvider(ctx, oc.Issuer)
+	if err != nil {
+		return nil, err
+	}
+	scopes := oc.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{oidc.ScopeOpenID, "profile", "email"}
+	}
+	return &oidcAuth{
+		oauth2Config: oauth2.Config{
+			ClientID:     oc.ClientID,
+			ClientSecret: oc.ClientSecret,
+			Endpoint:     provider.Endpoint(),
+			RedirectURL:  oc.RedirectURL,
+			Scopes:       scopes,
+		},
+		verifier:      provider.Verifier(&oidc.Config{ClientID: oc.ClientID}),
+		usernameClaim: oc.UsernameClaim,
+		groupsClaim:   oc.GroupsClaim,
+		basePath:      basePath,
+	}, nil
+}
+
+func randomString() (string, error) {
+	b := make([]byte, 128/8)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// startLogin redirects the browser to the provider's login page
+func (o *oidcAuth) startLogin(w http.ResponseWriter, r *http.Request, signer *cookieSigner, isHTTPS bool) {
+	st := oidcState{
+		Verifier: oauth2.GenerateVerifier(),
+		ReturnTo: r.URL.RequestURI(),
+	}
+	var err error
+	st.State, err = randomString()
+	if err == nil {
+		st.Nonce, err = randomString()
+	}
+	var value string
+	if err == nil {
+		value, err = signer.Encode(st)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    value,
+		Path:     o.basePath + oidcCallbackPath,
+		MaxAge:   int((10 * time.Minute).Seconds()),
+		Secure:   isHTTPS,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, o.oauth2Config.AuthCodeURL(st.State,
+		oauth2.S256ChallengeOption(st.Verifier),
+		oidc.Nonce(st.Nonce)), http.StatusFound)
+}
+
+// finishLogin handles the provider's redirect back to us and returns the logged-in user
+func (o *oidcAuth) finishLogin(r *http.Request, signer *cookieSigner) (*auth.User, string, error) {
+	c, err := r.Cookie(oidcStateCookie)
+	if err != nil {
+		return nil, "", err
+	}
+	var st oidcState
+	err = signer.Decode(c.Value, &st)
+	if err != nil {
+		return nil, "", err
+	}
+	q := r.URL.Query()
+	if errStr := q.Get("error"); errStr != "" {
+		//nolint:goerr113
+		return nil, "", fmt.Errorf("OIDC provider returned error: %s %s", errStr, q.Get("error_description"))
+	}
+	if q.Get("state") != st.State {
+		return nil, "", ErrOIDCStateMismatch
+	}
+	tok, err := o.oauth2Config.Exchange(r.Context(), q.Get("code"), oauth2.VerifierOption(st.Verifier))
+	if err != nil {
+		return nil, "", err
+	}
+	rawIDToken, ok := tok.Extra("id_token").(string)
+	if !ok {
+		return nil, "", ErrOIDCNoIDToken
+	}
+	idToken, err := o.verifier.Verify(r.Context(), rawIDToken)
+	if err != nil {
+		return nil, "", err
+	}
+	if idToken.Nonce != st.Nonce {
+		return nil, "", ErrOIDCNonceMismatch
+	}
+	user, err := o.userFromIDToken(idToken)
+	if err != nil {
+		return nil, "", err
+	}
+	return user, o.basePath + localPath(st.ReturnTo), nil
+}
+
+// localPath returns returnTo if it is a path on this server, or "/" if it could lead the browser elsewhere.
+// Browsers treat "//host" and "/\host" as URLs on another host.
+func localPath(returnTo string) string {
+	u, err := url.Parse(returnTo)
+	if err != nil || u.Scheme != "" || u.Host != "" || u.User != nil || !strings.HasPrefix(returnTo, "/") ||
+		strings.HasPrefix(returnTo, "//") || strings.HasPrefix(returnTo, "/\\") {
+		return "/"
+	}
+	return returnTo
+}
+
+// userFromIDToken extracts the username and groups from the claims of an ID token
+func (o *oidcAuth) userFromIDToken(idToken *oidc.IDToken) (*auth.User, error) {
+	var claims map[string]interface{}
+	err := idToken.Claims(&claims)
+	if err != nil {
+		return nil, err
+	}
+	name, ok := claims[o.usernameClaim].(string)
+	if !ok || name == "" {
+		return nil, fmt.Errorf("%w: no %s claim", ErrOIDCNoUsername, o.usernameClaim)
+	}
+	user := &auth.User{Name: name}
+	switch groups := claims[o.groupsClaim].(type) {
+	case []interface{}:
+		for _, g := range groups {
+			if gs, ok := g.(string); ok {
+				user.Groups = append(user.Groups, gs)
+			}
+		}
+	case string:
+		user.Groups = []string{groups}
+	}
+	return user, nil
+}
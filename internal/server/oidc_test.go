@@ -0,0 +1,395 @@
+package server
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/altinity/altinity-dashboard/internal/auth"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testClientID = "dashboard"
+const testClientSecret = "secret"
+const testRedirectURL = "https://dashboard.example/auth/callback"
+
+// fakeGrant is an authorization code issued by the fake issuer, and what it was issued for
+type fakeGrant struct {
+	challenge string
+	nonce     string
+	claims    map[string]interface{}
+}
+
+// fakeIssuer is a minimal OpenID Connect provider, with discovery, JWKS, authorization and token endpoints.
+// Its authorization endpoint logs the user in immediately, as if they had entered their credentials.
+type fakeIssuer struct {
+	srv    *httptest.Server
+	key    *rsa.PrivateKey
+	claims map[string]interface{}
+	// tamper, if set, alters each grant before it is recorded
+	tamper func(g *fakeGrant)
+	lock   sync.Mutex
+	grants map[string]*fakeGrant
+}
+
+func newFakeIssuer(t *testing.T, claims map[string]interface{}) *fakeIssuer {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeIssuer{
+		key:    key,
+		claims: claims,
+		grants: make(map[string]*fakeGrant),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", f.serveDiscovery)
+	mux.HandleFunc("/keys", f.serveKeys)
+	mux.HandleFunc("/authorize", f.serveAuthorize)
+	mux.HandleFunc("/token", f.serveToken)
+	f.srv = httptest.NewServer(mux)
+	t.Cleanup(f.srv.Close)
+	return f
+}
+
+func (f *fakeIssuer) serveDiscovery(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                f.srv.URL,
+		"authorization_endpoint":                f.srv.URL + "/authorize",
+		"token_endpoint":                        f.srv.URL + "/token",
+		"jwks_uri":                              f.srv.URL + "/keys",
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+	})
+}
+
+func (f *fakeIssuer) serveKeys(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"alg": "RS256",
+			"use": "sig",
+			"kid": "test",
+			"n":   base64.RawURLEncoding.EncodeToString(f.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(f.key.E)).Bytes()),
+		}},
+	})
+}
+
+func (f *fakeIssuer) serveAuthorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("client_id") != testClientID || q.Get("code_challenge_method") != "S256" {
+		http.Error(w, "bad authorization request", http.StatusBadRequest)
+		return
+	}
+	g := &fakeGrant{
+		challenge: q.Get("code_challenge"),
+		nonce:     q.Get("nonce"),
+		claims:    f.claims,
+	}
+	if f.tamper != nil {
+		f.tamper(g)
+	}
+	code, err := randomString()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	f.lock.Lock()
+	f.grants[code] = g
+	f.lock.Unlock()
+	http.Redirect(w, r, q.Get("redirect_uri")+"?"+url.Values{
+		"code":  {code},
+		"state": {q.Get("state")},
+	}.Encode(), http.StatusFound)
+}
+
+func (f *fakeIssuer) serveToken(w http.ResponseWriter, r *http.Request) {
+	id, secret, ok := r.BasicAuth()
+	if !ok {
+		id, secret = r.PostFormValue("client_id"), r.PostFormValue("client_secret")
+	}
+	if id != testClientID || secret != testClientSecret {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+	code := r.PostFormValue("code")
+	f.lock.Lock()
+	g, ok := f.grants[code]
+	delete(f.grants, code)
+	f.lock.Unlock()
+	sum := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+	if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != g.challenge {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+	idToken, err := f.sign(g)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": "access",
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     idToken,
+	})
+}
+
+// sign returns an RS256-signed ID token for a grant
+func (f *fakeIssuer) sign(g *fakeGrant) (string, error) {
+	now := time.Now()
+	claims := map[string]interface{}{
+		"iss":   f.srv.URL,
+		"aud":   testClientID,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+		"nonce": g.nonce,
+	}
+	for k, v := range g.claims {
+		claims[k] = v
+	}
+	var parts []string
+	for _, v := range []interface{}{map[string]string{"alg": "RS256", "kid": "test", "typ": "JWT"}, claims} {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return "", err
+		}
+		parts = append(parts, base64.RawURLEncoding.EncodeToString(data))
+	}
+	digest := sha256.Sum256([]byte(strings.Join(parts, ".")))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, f.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return strings.Join(parts, ".") + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// cookieNamed returns the cookie with the given name set by a response, or nil
+func cookieNamed(resp *http.Response, name string) *http.Cookie {
+	for _, c := range resp.Cookies() {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+func TestOIDCLogin(t *testing.T) {
+	tests := []struct {
+		name   string
+		claims map[string]interface{}
+		tamper func(g *fakeGrant)
+		// callback, if set, alters the callback request the browser makes
+		callback   func(q url.Values, state *http.Cookie)
+		wantStatus int
+		wantUser   *auth.User
+	}{
+		{
+			name:       "username and groups from claims",
+			claims:     map[string]interface{}{"sub": "1234", "email": "alice@example.com", "groups": []string{"admins", "dev"}},
+			wantStatus: http.StatusFound,
+			wantUser:   &auth.User{Name: "alice@example.com", Groups: []string{"admins", "dev"}, Role: auth.RoleAdmin},
+		},
+		{
+			name:       "username claim missing",
+			claims:     map[string]interface{}{"sub": "1234", "groups": "dev"},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "state mismatch",
+			claims:     map[string]interface{}{"sub": "1234"},
+			callback:   func(q url.Values, _ *http.Cookie) { q.Set("state", "forged") },
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:   "tampered state cookie",
+			claims: map[string]interface{}{"sub": "1234"},
+			callback: func(_ url.Values, state *http.Cookie) {
+				state.Value = "e30." + state.Value[strings.Index(state.Value, ".")+1:]
+			},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "PKCE verifier mismatch",
+			claims:     map[string]interface{}{"sub": "1234"},
+			tamper:     func(g *fakeGrant) { g.challenge = "not-the-challenge" },
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "nonce mismatch",
+			claims:     map[string]interface{}{"sub": "1234"},
+			tamper:     func(g *fakeGrant) { g.nonce = "replayed" },
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "provider error",
+			claims:     map[string]interface{}{"sub": "1234"},
+			callback:   func(q url.Values, _ *http.Cookie) { q.Del("code"); q.Set("error", "access_denied") },
+			wantStatus: http.StatusUnauthorized,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			issuer := newFakeIssuer(t, tt.claims)
+			issuer.tamper = tt.tamper
+			var gotUser *auth.User
+			h, err := NewHandler(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+				gotUser = auth.UserFromContext(r.Context())
+			}), &AuthConfig{
+				OIDC: &OIDCConfig{
+					Issuer:        issuer.srv.URL,
+					ClientID:      testClientID,
+					ClientSecret:  testClientSecret,
+					RedirectURL:   testRedirectURL,
+					UsernameClaim: "email",
+					GroupsClaim:   "groups",
+				},
+				IsHTTPS: true,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// An unauthenticated page load is redirected to the provider, with a signed state cookie
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/chis?namespace=test", nil))
+			resp := rec.Result()
+			if resp.StatusCode != http.StatusFound {
+				t.Fatalf("login start: got status %d, want %d", resp.StatusCode, http.StatusFound)
+			}
+			state := cookieNamed(resp, oidcStateCookie)
+			if state == nil || !state.HttpOnly || !state.Secure || state.Path != oidcCallbackPath {
+				t.Fatalf("login start: bad state cookie %v", state)
+			}
+
+			// The provider logs the user in and sends the browser back with a code
+			client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			}}
+			authResp, err := client.Get(resp.Header.Get("Location"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			_ = authResp.Body.Close()
+			callbackURL, err := url.Parse(authResp.Header.Get("Location"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasPrefix(callbackURL.String(), testRedirectURL) {
+				t.Fatalf("provider redirected to %s, want %s", callbackURL, testRedirectURL)
+			}
+			q := callbackURL.Query()
+			if tt.callback != nil {
+				tt.callback(q, state)
+			}
+			req := httptest.NewRequest(http.MethodGet, callbackURL.Path+"?"+q.Encode(), nil)
+			req.AddCookie(state)
+			rec = httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			resp = rec.Result()
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("callback: got status %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			sess := cookieNamed(resp, sessionCookie)
+			if tt.wantUser == nil {
+				if sess != nil {
+					t.Fatalf("callback: session cookie set on failed login")
+				}
+				return
+			}
+			if loc := resp.Header.Get("Location"); loc != "/chis?namespace=test" {
+				t.Errorf("callback: redirected to %q, want the page the login started from", loc)
+			}
+			if sess == nil || !sess.HttpOnly || !sess.Secure || sess.SameSite != http.SameSiteLaxMode {
+				t.Fatalf("callback: bad session cookie %v", sess)
+			}
+
+			// The session cookie authenticates later requests as the user in the ID token
+			req = httptest.NewRequest(http.MethodGet, "/api/v1/chis", nil)
+			req.AddCookie(sess)
+			rec = httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("session: got status %d, want %d", rec.Code, http.StatusOK)
+			}
+			if !reflect.DeepEqual(gotUser, tt.wantUser) {
+				t.Errorf("session: got user %+v, want %+v", gotUser, tt.wantUser)
+			}
+		})
+	}
+}
+
+func TestCookieSigner(t *testing.T) {
+	s, err := newCookieSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := newCookieSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	value, err := s.Encode(oidcState{State: "s", Nonce: "n"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, sig, _ := strings.Cut(value, ".")
+	tests := []struct {
+		name    string
+		signer  *cookieSigner
+		value   string
+		wantErr bool
+	}{
+		{name: "valid", signer: s, value: value},
+		{name: "other key", signer: other, value: value, wantErr: true},
+		{name: "altered payload", signer: s, value: "e30." + sig, wantErr: true},
+		{name: "missing signature", signer: s, value: payload, wantErr: true},
+		{name: "empty", signer: s, value: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var st oidcState
+			err := tt.signer.Decode(tt.value, &st)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && (st.State != "s" || st.Nonce != "n") {
+				t.Errorf("decoded %+v", st)
+			}
+		})
+	}
+}
+
+func TestLocalPath(t *testing.T) {
+	tests := []struct {
+		returnTo string
+		want     string
+	}{
+		{"/", "/"},
+		{"/chis?namespace=default", "/chis?namespace=default"},
+		{"", "/"},
+		{"chis", "/"},
+		{"//evil.example/", "/"},
+		{"/\\evil.example", "/"},
+		{"https://evil.example/", "/"},
+		{"javascript:alert(1)", "/"},
+		{"/%zz", "/"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.returnTo, func(t *testing.T) {
+			if got := localPath(tt.returnTo); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,49 +1,108 @@
 package server
 
 import (
+	"bytes"
 	"context"
-	"crypto/rand"
+	"crypto/tls"
 	"embed"
-	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/altinity/altinity-dashboard/internal/api"
+	"github.com/altinity/altinity-dashboard/internal/audit"
+	"github.com/altinity/altinity-dashboard/internal/auth"
 	"github.com/altinity/altinity-dashboard/internal/certs"
+	"github.com/altinity/altinity-dashboard/internal/logging"
+	"github.com/altinity/altinity-dashboard/internal/metrics"
 	"github.com/altinity/altinity-dashboard/internal/utils"
 	restfulspec "github.com/emicklei/go-restful-openapi/v2"
 	"github.com/emicklei/go-restful/v3"
 	"github.com/go-openapi/spec"
+	"html"
 	"io/fs"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"log/slog"
+	"net"
 	"net/http"
+	"os"
 	"regexp"
 	"strconv"
+	"strings"
 	"time"
 )
 
 type Config struct {
-	TLSCert     string
-	TLSKey      string
-	SelfSigned  bool
-	Debug       bool
-	Kubeconfig  string
-	BindHost    string
-	BindPort    string
-	DevMode     bool
-	NoToken     bool
-	AppVersion  string
-	ChopRelease string
-	UIFiles     *embed.FS
-	EmbedFiles  *embed.FS
-	URL         string
-	IsHTTPS     bool
-	ServerError error
-	Context     context.Context
-	Cancel      func()
+	TLSCert          string
+	TLSKey           string
+	TLSClientCA      string
+	TLSClientAuth    string
+	SelfSigned       bool
+	CertDir          string
+	Kubeconfig       string
+	KubeContext      string
+	Clusters         string
+	BindHost         string
+	BindPort         string
+	BasePath         string
+	DevMode          bool
+	NoToken          bool
+	OIDC             OIDCConfig
+	PolicyFile       string
+	Impersonate      bool
+	AuditLog         string
+	TokensFile       string
+	TokensSecret     string
+	TokenReview      bool
+	OperatorVersion  string
+	DefaultNamespace string
+	NoCache          bool
+	CacheSyncTime    time.Duration
+	NoMetrics        bool
+	MetricsAddr      string
+	SessionIdle      time.Duration
+	SessionMax       time.Duration
+	AppVersion       string
+	ChopRelease      string
+	UIFiles          *embed.FS
+	EmbedFiles       *embed.FS
+	URL              string
+	IsHTTPS          bool
+	ServerError      error
+	Context          context.Context
+	Cancel           func()
+	connHost         string
+	ca               *certs.CA
+	tlsReloader      *certs.Reloader
+	authHandler      *Handler
+	srv              *http.Server
+	metricsSrv       *http.Server
+	stopping         chan struct{}
 }
 
+var basePathRegexp = regexp.MustCompile(`^(/[A-Za-z0-9._-]+)+$`)
+
+// tlsReloadInterval is how often the TLS certificate and key files are checked for changes
+const tlsReloadInterval = 10 * time.Second
+
+// kubeconfigReloadInterval is how often the kubeconfig files are checked for changes
+const kubeconfigReloadInterval = 10 * time.Second
+
+// caCertPath is where the self-signed CA certificate can be downloaded
+const caCertPath = "/ca.crt"
+
 var ErrTLSCertKeyBothOrNeither = errors.New("TLS cert and key must both be provided or neither")
 var ErrTLSOrSelfSigned = errors.New("cannot provide TLS certificate and also run self-signed")
+var ErrOIDCClientIDRequired = errors.New("an OIDC client ID must be provided along with the OIDC issuer")
+var ErrOIDCOrNoToken = errors.New("cannot use OIDC login and also run without authentication")
+var ErrTokensFileOrSecret = errors.New("API tokens can be stored in a file or a secret, but not both")
+var ErrTokensSecretName = errors.New("API tokens secret must be given as namespace/name")
+var ErrTokenReviewOrNoToken = errors.New("cannot use TokenReview authentication and also run without authentication")
+var ErrImpersonateNeedsLogin = errors.New("impersonation requires users to authenticate with OIDC, TokenReview or client certificates")
+var ErrBasePathInvalid = errors.New("base path may only contain letters, digits, slashes, dots, dashes and underscores")
+var ErrTLSClientCANeedsTLS = errors.New("client certificates can only be used when serving TLS")
+var ErrContextOrClusters = errors.New("cannot provide a context and also a list of clusters (give each cluster's context in the list)")
+var ErrMetricsAddrOrNoMetrics = errors.New("cannot provide a metrics address and also disable metrics")
+var ErrDefaultNamespaceInvalid = errors.New("default namespace is not a valid namespace name")
 
 func (c *Config) RunServer() error {
 	// Check CLI flags for correctness
@@ -53,21 +112,74 @@ func (c *Config) RunServer() error {
 	if (c.SelfSigned) && (c.TLSCert != "") {
 		return ErrTLSOrSelfSigned
 	}
-
-	// Enable debug logging, if requested
-	if c.Debug {
-		api.ErrorsToConsole = true
+	if c.OIDC.Issuer != "" && c.OIDC.ClientID == "" {
+		return ErrOIDCClientIDRequired
+	}
+	if c.OIDC.Issuer != "" && c.NoToken {
+		return ErrOIDCOrNoToken
+	}
+	if c.TokensFile != "" && c.TokensSecret != "" {
+		return ErrTokensFileOrSecret
+	}
+	if c.TokenReview && c.NoToken {
+		return ErrTokenReviewOrNoToken
 	}
+	c.BasePath = strings.TrimRight(c.BasePath, "/")
+	if c.BasePath != "" && !strings.HasPrefix(c.BasePath, "/") {
+		c.BasePath = "/" + c.BasePath
+	}
+	if c.BasePath != "" && !basePathRegexp.MatchString(c.BasePath) {
+		return ErrBasePathInvalid
+	}
+	if c.TLSClientCA != "" && c.TLSCert == "" && !c.SelfSigned {
+		return ErrTLSClientCANeedsTLS
+	}
+	if c.KubeContext != "" && c.Clusters != "" {
+		return ErrContextOrClusters
+	}
+	if c.Impersonate && c.OIDC.Issuer == "" && !c.TokenReview && c.TLSClientCA == "" {
+		return ErrImpersonateNeedsLogin
+	}
+	if c.NoMetrics && c.MetricsAddr != "" {
+		return ErrMetricsAddrOrNoMetrics
+	}
+	if c.DefaultNamespace != "" && len(validation.IsDNS1123Label(c.DefaultNamespace)) > 0 {
+		return fmt.Errorf("%w: %s", ErrDefaultNamespaceInvalid, c.DefaultNamespace)
+	}
+
+	// Make Kubernetes requests as the logged-in user, if requested
+	api.Impersonate = c.Impersonate
 
-	// Connect to Kubernetes
-	err := utils.InitK8s(c.Kubeconfig)
+	// Connect to Kubernetes.  Cached reads would bypass the API server's authorization of the logged-in
+	// user, so the cache is not used when impersonating.
+	cacheOpts := utils.CacheOptions{
+		Enabled:     !c.NoCache && !c.Impersonate,
+		SyncTimeout: c.CacheSyncTime,
+	}
+	var err error
+	if c.Clusters == "" {
+		err = utils.InitK8s(c.Kubeconfig, c.KubeContext, cacheOpts)
+	} else {
+		var specs []utils.ClusterSpec
+		specs, err = parseClusterSpecs(c.Clusters, c.Kubeconfig)
+		if err != nil {
+			return err
+		}
+		err = utils.InitClusters(specs, cacheOpts)
+	}
 	if err != nil {
 		return fmt.Errorf("could not connect to Kubernetes: %w", err)
 	}
 
+	// Determine the host users will connect to
+	c.connHost, err = utils.BindHostToLocalHost(c.BindHost)
+	if err != nil {
+		return err
+	}
+
 	// If self-signed, generate the certificates
 	if c.SelfSigned {
-		c.TLSCert, c.TLSKey, err = certs.GenerateSelfSignedCerts(true)
+		err = c.generateSelfSignedCerts()
 		if err != nil {
 			return fmt.Errorf("error generating self-signed certificate: %w", err)
 		}
@@ -89,14 +201,18 @@ func (c *Config) RunServer() error {
 		return fmt.Errorf("error reading embedded UI files: %w", err)
 	}
 	for name, content := range map[string]string{
-		"devmode":      strconv.FormatBool(c.DevMode),
-		"version":      c.AppVersion,
-		"chop-release": c.ChopRelease,
+		"devmode":           strconv.FormatBool(c.DevMode),
+		"version":           c.AppVersion,
+		"chop-release":      c.ChopRelease,
+		"operator-version":  c.OperatorVersion,
+		"default-namespace": c.DefaultNamespace,
 	} {
 		re := regexp.MustCompile(fmt.Sprintf(`meta name="%s" content="(\w*)"`, name))
 		indexHTML = re.ReplaceAll(indexHTML,
-			[]byte(fmt.Sprintf(`meta name="%s" content="%s"`, name, content)))
+			[]byte(fmt.Sprintf(`meta name="%s" content="%s"`, name, html.EscapeString(content))))
 	}
+	indexHTML = bytes.Replace(indexHTML, []byte(`<base href="/">`),
+		[]byte(fmt.Sprintf(`<base href="%s/">`, c.BasePath)), 1)
 
 	// Create HTTP router object
 	httpMux := http.NewServeMux()
@@ -104,23 +220,73 @@ func (c *Config) RunServer() error {
 	// Create API handlers & docs
 	rc := restful.NewContainer()
 	rc.ServeMux = httpMux
+	rc.Filter(metrics.Filter)
+	auditLog, err := audit.NewLog(c.AuditLog)
+	if err != nil {
+		return fmt.Errorf("error opening audit log: %w", err)
+	}
+	var tokens *auth.Tokens
+	var tokenStore auth.TokenStore
+	if c.TokensFile != "" {
+		tokenStore = &auth.FileTokenStore{Filename: c.TokensFile}
+	} else if c.TokensSecret != "" {
+		ns, name, ok := strings.Cut(c.TokensSecret, "/")
+		if !ok || ns == "" || name == "" {
+			return ErrTokensSecretName
+		}
+		tokenStore = &auth.SecretTokenStore{Namespace: ns, Name: name}
+	}
+	if tokenStore != nil {
+		tokens, err = auth.NewTokens(tokenStore)
+		if err != nil {
+			return fmt.Errorf("error loading API tokens: %w", err)
+		}
+	}
+	c.stopping = make(chan struct{})
 	wsi := api.WebServiceInfo{
-		Version:     c.AppVersion,
-		ChopRelease: c.ChopRelease,
-		Embed:       c.EmbedFiles,
+		Version:         c.AppVersion,
+		ChopRelease:     c.ChopRelease,
+		OperatorVersion: c.OperatorVersion,
+		Embed:           c.EmbedFiles,
+		Audit:           auditLog,
+		Tokens:          tokens,
+		Stopping:        c.stopping,
+	}
+	resources := []api.WebService{
+		&api.AuditResource{},
+		&api.ClusterResource{},
 	}
-	for _, resource := range []api.WebService{
+	if tokens != nil {
+		resources = append(resources, &api.TokenResource{})
+	}
+	// These act on Kubernetes, so are also served under /api/v1/clusters/{cluster} for each cluster
+	clusterResources := []api.WebService{
 		&api.DashboardResource{},
 		&api.NamespaceResource{},
 		&api.OperatorResource{},
 		&api.ChiResource{},
-	} {
-		var ws *restful.WebService
-		ws, err = resource.WebService(&wsi)
-		if err != nil {
-			return fmt.Errorf("error initializing %s web service: %w", resource.Name(), err)
+		&api.ContextResource{},
+		&api.WatchResource{},
+	}
+	clusterWSI := wsi
+	clusterWSI.ClusterScoped = true
+	addResources := func(resources []api.WebService, wsi *api.WebServiceInfo) error {
+		for _, resource := range resources {
+			ws, err := resource.WebService(wsi)
+			if err != nil {
+				return fmt.Errorf("error initializing %s web service: %w", resource.Name(), err)
+			}
+			rc.Add(ws)
 		}
-		rc.Add(ws)
+		return nil
+	}
+	err = addResources(append(resources, clusterResources...), &wsi)
+	if err != nil {
+		return err
+	}
+	err = addResources(clusterResources, &clusterWSI)
+	if err != nil {
+		return err
 	}
 	config := restfulspec.Config{
 		WebServices:                   rc.RegisteredWebServices(), // you control what services are visible
@@ -163,60 +329,158 @@ func (c *Config) RunServer() error {
 			(r.URL.Path == "/operators") ||
 			(r.URL.Path == "/chis") ||
 			(r.URL.Path == "/devel") {
-			_, _ = w.Write(indexHTML)
+			_, _ = w.Write(bytes.Replace(indexHTML, []byte(csrfMetaTag),
+				[]byte(fmt.Sprintf(`meta name="csrf-token" content="%s"`, csrfTokenFromContext(r.Context()))), 1))
 		} else {
 			subServer.ServeHTTP(w, r)
 		}
 	})
 
-	// Configure auth middleware
+	// Determine the address users will connect to
 	c.IsHTTPS = c.TLSCert != ""
-	var httpHandler http.Handler
-	var authToken string
-	if c.NoToken {
-		httpHandler = httpMux
-	} else {
-		// Generate auth token
-		randBytes := make([]byte, 256/8)
-		_, err = rand.Read(randBytes)
+
+	// Configure auth middleware
+	ac := AuthConfig{
+		NoToken:            c.NoToken,
+		Tokens:             tokens,
+		TokenReview:        c.TokenReview,
+		ClientCerts:        c.TLSClientCA != "",
+		SessionIdleTimeout: c.SessionIdle,
+		SessionMaxLifetime: c.SessionMax,
+		BasePath:           c.BasePath,
+		IsHTTPS:            c.IsHTTPS,
+	}
+	if c.PolicyFile != "" {
+		ac.Policy, err = auth.LoadPolicy(c.PolicyFile)
+		if err != nil {
+			return fmt.Errorf("error loading policy file: %w", err)
+		}
+	}
+	switch {
+	case c.NoToken:
+	case c.OIDC.Issuer != "":
+		if c.OIDC.RedirectURL == "" {
+			c.OIDC.RedirectURL = fmt.Sprintf("%s%s", c.baseURL(), oidcCallbackPath)
+		}
+		ac.OIDC = &c.OIDC
+	case c.TokenReview:
+		// Callers must present a Kubernetes bearer token, so there is no startup token
+	default:
+		// Generate a one-time login token
+		ac.AuthToken, err = newLoginToken()
 		if err != nil {
 			return fmt.Errorf("error generating random number: %w", err)
 		}
-		authToken = base64.RawURLEncoding.EncodeToString(randBytes)
-		httpHandler = NewHandler(httpMux, authToken, c.IsHTTPS)
+	}
+	c.authHandler, err = NewHandler(httpMux, &ac)
+	if err != nil {
+		return fmt.Errorf("error setting up authentication: %w", err)
 	}
 
 	// Set up the server
 	bindStr := fmt.Sprintf("%s:%s", c.BindHost, c.BindPort)
-	var authStr string
-	if authToken != "" {
-		authStr = fmt.Sprintf("?token=%s", authToken)
+	c.setURL(ac.AuthToken)
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
 	}
-	var connHost string
-	connHost, err = utils.BindHostToLocalHost(c.BindHost)
-	if err != nil {
-		return err
-	}
-	var urlScheme string
 	if c.IsHTTPS {
-		urlScheme = "https"
-	} else {
-		urlScheme = "http"
+		c.tlsReloader, err = certs.NewReloader(c.TLSCert, c.TLSKey)
+		if err != nil {
+			return fmt.Errorf("error loading TLS certificate: %w", err)
+		}
+		tlsConfig.GetCertificate = c.tlsReloader.GetCertificate
+	}
+	if c.TLSClientCA != "" {
+		tlsConfig.ClientAuth, err = clientAuthType(c.TLSClientAuth)
+		if err != nil {
+			return err
+		}
+		tlsConfig.ClientCAs, err = loadClientCAs(c.TLSClientCA)
+		if err != nil {
+			return fmt.Errorf("error loading client CA certificates: %w", err)
+		}
 	}
-	c.URL = fmt.Sprintf("%s://%s:%s%s", urlScheme, connHost, c.BindPort, authStr)
+
+	// Serve the health, readiness, version and metrics endpoints and the CA certificate without
+	// authentication, so they can be used by probes, scrapers and users who don't yet trust the server.
+	// Metrics can instead be served on their own address, so that access to them can be restricted.
+	publicMux := http.NewServeMux()
+	publicMux.Handle("/", c.authHandler)
+	publicMux.HandleFunc(healthzPath, c.serveHealthz)
+	publicMux.HandleFunc(readyzPath, c.serveReadyz)
+	publicMux.HandleFunc(versionPath, c.serveVersion)
+	var metricsListener net.Listener
+	switch {
+	case c.NoMetrics:
+	case c.MetricsAddr != "":
+		metricsMux := http.NewServeMux()
+		metricsMux.Handle(metricsPath, metrics.Handler())
+		c.metricsSrv = &http.Server{
+			Addr:              c.MetricsAddr,
+			Handler:           metricsMux,
+			ReadHeaderTimeout: 3 * time.Second,
+		}
+		metricsListener, err = net.Listen("tcp", c.MetricsAddr)
+		if err != nil {
+			return fmt.Errorf("error listening for metrics: %w", err)
+		}
+	default:
+		publicMux.Handle(metricsPath, metrics.Handler())
+	}
+	if c.ca != nil {
+		publicMux.HandleFunc(caCertPath, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/x-x509-ca-cert")
+			w.Header().Set("Content-Disposition", `attachment; filename="altinity-dashboard-ca.crt"`)
+			_, _ = w.Write(c.ca.CertPEM)
+		})
+	}
+
+	// Serve everything under the base path, if there is one
+	var rootHandler http.Handler = publicMux
+	if c.BasePath != "" {
+		rootMux := http.NewServeMux()
+		rootMux.Handle(c.BasePath+"/", http.StripPrefix(c.BasePath, publicMux))
+		rootMux.HandleFunc(c.BasePath, func(w http.ResponseWriter, r *http.Request) {
+			u := *r.URL
+			u.Path = c.BasePath + "/"
+			u.RawPath = ""
+			http.Redirect(w, r, u.String(), http.StatusMovedPermanently)
+		})
+		rootHandler = rootMux
+	}
+
+	// Log every request, tagging it with a request ID
+	rootHandler = logging.Middleware(rootHandler)
 
 	// Start the server, but capture errors if it immediately fails to start
 	c.Context, c.Cancel = context.WithCancel(context.Background())
+	c.srv = &http.Server{
+		Addr:              bindStr,
+		Handler:           rootHandler,
+		TLSConfig:         tlsConfig,
+		ReadHeaderTimeout: 3 * time.Second,
+	}
+	// Shutdown waits for requests to finish, so tell streams to end
+	c.srv.RegisterOnShutdown(func() { close(c.stopping) })
+	go utils.WatchKubeconfigs(c.Context, kubeconfigReloadInterval)
+	if c.metricsSrv != nil {
+		go func() {
+			err := c.metricsSrv.Serve(metricsListener)
+			if !errors.Is(err, http.ErrServerClosed) {
+				slog.Error("Metrics server failed", slog.Any("err", err))
+			}
+		}()
+	}
 	go func() {
-		srv := &http.Server{
-			Addr:              bindStr,
-			Handler:           httpHandler,
-			ReadHeaderTimeout: 3 * time.Second,
-		}
+		var err error
 		if c.IsHTTPS {
-			c.ServerError = srv.ListenAndServeTLS(c.TLSCert, c.TLSKey)
+			go c.tlsReloader.Watch(c.Context, tlsReloadInterval)
+			err = c.srv.ListenAndServeTLS("", "")
 		} else {
-			c.ServerError = srv.ListenAndServe()
+			err = c.srv.ListenAndServe()
+		}
+		if !errors.Is(err, http.ErrServerClosed) {
+			c.ServerError = err
 		}
 		c.Cancel()
 	}()
@@ -229,7 +493,85 @@ func (c *Config) RunServer() error {
 	}
 }
 
+// Shutdown stops accepting connections and waits up to timeout for in-flight requests, such as
+// operator deployments, to finish.  Connections still open after the timeout are closed.
+func (c *Config) Shutdown(timeout time.Duration) error {
+	if c.srv == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if c.metricsSrv != nil {
+		_ = c.metricsSrv.Close()
+	}
+	err := c.srv.Shutdown(ctx)
+	if err != nil {
+		_ = c.srv.Close()
+		return fmt.Errorf("requests still in progress after %s: %w", timeout, err)
+	}
+	return nil
+}
+
+// generateSelfSignedCerts loads or creates the local CA and uses it to issue a server certificate
+// for the addresses users are likely to connect to
+func (c *Config) generateSelfSignedCerts() error {
+	var err error
+	certDir := c.CertDir
+	if certDir == "" {
+		certDir, err = certs.DefaultCADir()
+		if err != nil {
+			return err
+		}
+	}
+	c.ca, err = certs.LoadOrCreateCA(certDir)
+	if err != nil {
+		return err
+	}
+	hosts := []string{"localhost", "127.0.0.1", "::1", c.connHost}
+	if hostname, err := os.Hostname(); err == nil {
+		hosts = append(hosts, hostname)
+	}
+	c.TLSCert, c.TLSKey, err = c.ca.GenerateServerCert(hosts, true)
+	return err
+}
+
+// setURL sets the URL users can connect to, including a login token if there is one
+func (c *Config) setURL(authToken string) {
+	var authStr string
+	if authToken != "" {
+		authStr = fmt.Sprintf("?token=%s", authToken)
+	}
+	c.URL = fmt.Sprintf("%s/%s", c.baseURL(), authStr)
+}
+
+// RotateToken replaces the login token, updating URL to contain the new one
+func (c *Config) RotateToken() error {
+	if c.authHandler == nil {
+		return ErrNoLoginToken
+	}
+	token, err := c.authHandler.RotateLoginToken()
+	if err != nil {
+		return err
+	}
+	c.setURL(token)
+	return nil
+}
+
+// baseURL returns the URL, without any auth token, that users can connect to
+func (c *Config) baseURL() string {
+	var urlScheme string
+	if c.IsHTTPS {
+		urlScheme = "https"
+	} else {
+		urlScheme = "http"
+	}
+	return fmt.Sprintf("%s://%s:%s%s", urlScheme, c.connHost, c.BindPort, c.BasePath)
+}
+
 func (c *Config) enrichSwaggerObject(swo *spec.Swagger) {
+	if c.BasePath != "" {
+		swo.BasePath = c.BasePath
+	}
 	swo.Info = &spec.Info{
 		InfoProps: spec.InfoProps{
 			Title: "Altinity Dashboard",
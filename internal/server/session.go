@@ -0,0 +1,138 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/altinity/altinity-dashboard/internal/auth"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrInvalidSignature = errors.New("invalid cookie signature")
+
+// cookieSigner produces and checks tamper-proof cookie values
+type cookieSigner struct {
+	key []byte
+}
+
+// newCookieSigner creates a cookieSigner with a random key
+func newCookieSigner() (*cookieSigner, error) {
+	key := make([]byte, 256/8)
+	_, err := rand.Read(key)
+	if err != nil {
+		return nil, err
+	}
+	return &cookieSigner{key: key}, nil
+}
+
+func (s *cookieSigner) mac(payload string) string {
+	m := hmac.New(sha256.New, s.key)
+	_, _ = m.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(m.Sum(nil))
+}
+
+// Encode serializes v to JSON and returns it along with its signature
+func (s *cookieSigner) Encode(v interface{}) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	return payload + "." + s.mac(payload), nil
+}
+
+// Decode checks the signature of a value produced by Encode and deserializes it into v
+func (s *cookieSigner) Decode(value string, v interface{}) error {
+	payload, sig, ok := strings.Cut(value, ".")
+	if !ok || !hmac.Equal([]byte(sig), []byte(s.mac(payload))) {
+		return ErrInvalidSignature
+	}
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// session is a logged-in browser session
+type session struct {
+	user     auth.User
+	created  time.Time
+	lastSeen time.Time
+}
+
+// sessionStore keeps track of logged-in sessions, expiring them after a period of inactivity or
+// after a maximum lifetime
+type sessionStore struct {
+	lock        sync.Mutex
+	sessions    map[string]*session
+	idleTimeout time.Duration
+	maxLifetime time.Duration
+}
+
+func newSessionStore(idleTimeout time.Duration, maxLifetime time.Duration) *sessionStore {
+	return &sessionStore{
+		sessions:    make(map[string]*session),
+		idleTimeout: idleTimeout,
+		maxLifetime: maxLifetime,
+	}
+}
+
+// expired reports whether a session has timed out
+func (s *sessionStore) expired(sess *session, now time.Time) bool {
+	return (s.idleTimeout > 0 && now.Sub(sess.lastSeen) > s.idleTimeout) ||
+		(s.maxLifetime > 0 && now.Sub(sess.created) > s.maxLifetime)
+}
+
+// create starts a new session for a user and returns its ID
+func (s *sessionStore) create(user *auth.User) (string, error) {
+	id, err := randomString()
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	// Clean out expired sessions, so abandoned ones don't accumulate
+	for sid, sess := range s.sessions {
+		if s.expired(sess, now) {
+			delete(s.sessions, sid)
+		}
+	}
+	s.sessions[id] = &session{
+		user:     *user,
+		created:  now,
+		lastSeen: now,
+	}
+	return id, nil
+}
+
+// get returns the user of a session, or nil if the session does not exist or has expired
+func (s *sessionStore) get(id string) *auth.User {
+	now := time.Now()
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	sess, ok := s.sessions[id]
+	if !ok {
+		return nil
+	}
+	if s.expired(sess, now) {
+		delete(s.sessions, id)
+		return nil
+	}
+	sess.lastSeen = now
+	u := sess.user
+	return &u
+}
+
+// delete ends a session
+func (s *sessionStore) delete(id string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.sessions, id)
+}
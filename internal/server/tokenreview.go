@@ -0,0 +1,127 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"github.com/altinity/altinity-dashboard/internal/auth"
+	"github.com/altinity/altinity-dashboard/internal/utils"
+	authnv1 "k8s.io/api/authentication/v1"
+	authzv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrTokenReviewRejected = errors.New("bearer token was not accepted by Kubernetes")
+
+// reviewCacheTTL is how long TokenReview and SubjectAccessReview results are reused
+const reviewCacheTTL = time.Minute
+
+// reviewCacheMax is the number of cached results above which the cache is cleared
+const reviewCacheMax = 1000
+
+type cachedReview struct {
+	user    *auth.User
+	allowed bool
+	expires time.Time
+}
+
+// tokenReviewer authenticates bearer tokens with the Kubernetes TokenReview API, and authorizes
+// requests with the SubjectAccessReview API
+type tokenReviewer struct {
+	lock  sync.Mutex
+	cache map[string]cachedReview
+}
+
+func newTokenReviewer() *tokenReviewer {
+	return &tokenReviewer{
+		cache: make(map[string]cachedReview),
+	}
+}
+
+func (t *tokenReviewer) getCached(key string) (cachedReview, bool) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	cr, ok := t.cache[key]
+	if !ok || time.Now().After(cr.expires) {
+		return cachedReview{}, false
+	}
+	return cr, true
+}
+
+func (t *tokenReviewer) putCached(key string, cr cachedReview) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if len(t.cache) >= reviewCacheMax {
+		t.cache = make(map[string]cachedReview)
+	}
+	cr.expires = time.Now().Add(reviewCacheTTL)
+	t.cache[key] = cr
+}
+
+// authenticate returns the user a bearer token belongs to
+func (t *tokenReviewer) authenticate(ctx context.Context, token string) (*auth.User, error) {
+	sum := sha256.Sum256([]byte(token))
+	key := "token\x00" + string(sum[:])
+	if cr, ok := t.getCached(key); ok {
+		if cr.user == nil {
+			return nil, ErrTokenReviewRejected
+		}
+		return cr.user, nil
+	}
+
+	k := utils.GetK8s()
+	defer func() { k.ReleaseK8s() }()
+	tr, err := k.Clientset.AuthenticationV1().TokenReviews().Create(ctx, &authnv1.TokenReview{
+		Spec: authnv1.TokenReviewSpec{
+			Token: token,
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return nil, err
+	}
+	if !tr.Status.Authenticated {
+		t.putCached(key, cachedReview{})
+		return nil, ErrTokenReviewRejected
+	}
+	user := &auth.User{
+		Name:   tr.Status.User.Username,
+		Groups: tr.Status.User.Groups,
+	}
+	t.putCached(key, cachedReview{user: user})
+	return user, nil
+}
+
+// authorize checks whether Kubernetes RBAC allows a user to make a request.  Requests are checked as
+// non-resource URLs, so access is granted with ClusterRole rules such as:
+//
+//	nonResourceURLs: ["/api/v1/*"]
+//	verbs: ["get"]
+func (t *tokenReviewer) authorize(ctx context.Context, user *auth.User, r *http.Request) (bool, error) {
+	verb := strings.ToLower(r.Method)
+	key := strings.Join(append([]string{"sar", user.Name, verb, r.URL.Path}, user.Groups...), "\x00")
+	if cr, ok := t.getCached(key); ok {
+		return cr.allowed, nil
+	}
+
+	k := utils.GetK8s()
+	defer func() { k.ReleaseK8s() }()
+	sar, err := k.Clientset.AuthorizationV1().SubjectAccessReviews().Create(ctx, &authzv1.SubjectAccessReview{
+		Spec: authzv1.SubjectAccessReviewSpec{
+			NonResourceAttributes: &authzv1.NonResourceAttributes{
+				Path: r.URL.Path,
+				Verb: verb,
+			},
+			User:   user.Name,
+			Groups: user.Groups,
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return false, err
+	}
+	t.putCached(key, cachedReview{allowed: sar.Status.Allowed})
+	return sar.Status.Allowed, nil
+}
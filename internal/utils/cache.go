@@ -0,0 +1,351 @@
+package utils
+
+import (
+	"context"
+	chopv1 "github.com/altinity/clickhouse-operator/pkg/apis/clickhouse.altinity.com/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	errors2 "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Resources held in the cache
+const (
+	ResourceCHIs        = "clickhouseinstallations"
+	ResourcePods        = "pods"
+	ResourcePVCs        = "persistentvolumeclaims"
+	ResourcePVs         = "persistentvolumes"
+	ResourceServices    = "services"
+	ResourceDeployments = "deployments"
+	ResourceNamespaces  = "namespaces"
+	chiLabel            = "clickhouse.altinity.com/chi"
+)
+
+// crdCheckInterval is how often a cache without ClickHouseInstallations checks whether their CRD has been installed
+const crdCheckInterval = time.Minute
+
+// Cache serves reads of the resources shown by the dashboard from memory, kept up to date by informers
+type Cache struct {
+	config    *rest.Config
+	stop      chan struct{}
+	resources map[string]*cachedResource
+}
+
+// cachedResource is an informer for one kind of resource, along with the state of its connection to the
+// API server
+type cachedResource struct {
+	informer cache.SharedIndexInformer
+	// requiredLabel and requiredValue restrict the cache to objects with that label (with any value, if
+	// requiredValue is empty), so only selectors that require the same can be served from it
+	requiredLabel string
+	requiredValue string
+	started       bool
+	lock          sync.Mutex
+	updated       time.Time
+	lastError     error
+}
+
+// CacheStatus describes how current the cached copy of a resource is
+type CacheStatus struct {
+	Resource string    `json:"resource" description:"name of the resource"`
+	Synced   bool      `json:"synced" description:"whether the initial listing has completed"`
+	Stale    bool      `json:"stale" description:"whether the cache may be out of date, because it has not synced or has lost its connection to the API server"`
+	Updated  time.Time `json:"updated" description:"time the cache last heard from the API server"`
+	Error    string    `json:"error,omitempty" description:"error from the last attempt to list or watch the resource, if it failed"`
+}
+
+// newCache creates the informers for a cluster, using its current clients.  The caller must hold the write lock.
+func newCache(k *K8s) *Cache {
+	c := &Cache{
+		config:    k.Config,
+		stop:      make(chan struct{}),
+		resources: make(map[string]*cachedResource),
+	}
+	core := k.Clientset.CoreV1().RESTClient()
+	apps := k.Clientset.AppsV1().RESTClient()
+	c.add(ResourcePods, &corev1.Pod{}, cache.NewListWatchFromClient(core, ResourcePods, "", fields.Everything()))
+	c.add(ResourcePVCs, &corev1.PersistentVolumeClaim{},
+		cache.NewListWatchFromClient(core, ResourcePVCs, "", fields.Everything()))
+	c.add(ResourcePVs, &corev1.PersistentVolume{}, cache.NewListWatchFromClient(core, ResourcePVs, "", fields.Everything()))
+	c.add(ResourceNamespaces, &corev1.Namespace{},
+		cache.NewListWatchFromClient(core, ResourceNamespaces, "", fields.Everything()))
+	// Only the services and deployments the dashboard shows are cached, rather than every one in the cluster
+	c.addFiltered(ResourceServices, &corev1.Service{}, core, chiLabel, "")
+	c.addFiltered(ResourceDeployments, &appsv1.Deployment{}, apps, "app", "clickhouse-operator")
+	c.addCHIs(k)
+	return c
+}
+
+// addCHIs creates the informer for ClickHouseInstallations, if it doesn't exist yet and the CRD is installed.
+// Without the CRD, the informer could never sync, and would keep failing to list.  The caller must hold the
+// write lock, and call start to run the informer.
+func (c *Cache) addCHIs(k *K8s) {
+	if _, exists := c.resources[ResourceCHIs]; exists || !chiCRDInstalled(k) {
+		return
+	}
+	chis := k.ChopClientset.ClickhouseV1()
+	c.add(ResourceCHIs, &chopv1.ClickHouseInstallation{}, &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			return chis.ClickHouseInstallations("").List(context.TODO(), options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			return chis.ClickHouseInstallations("").Watch(context.TODO(), options)
+		},
+	})
+}
+
+// chiCRDInstalled reports whether the API server serves ClickHouseInstallations.  If discovery fails for
+// another reason, such as the API server being unreachable, the CRD is assumed to be installed.
+func chiCRDInstalled(k *K8s) bool {
+	resources, err := k.DiscoveryClient.ServerResourcesForGroupVersion(chopv1.SchemeGroupVersion.String())
+	if err != nil {
+		return !errors2.IsNotFound(err)
+	}
+	for _, res := range resources.APIResources {
+		if res.Name == ResourceCHIs {
+			return true
+		}
+	}
+	return false
+}
+
+// watchForCHICRD checks for the ClickHouseInstallation CRD until it is installed, and then caches
+// ClickHouseInstallations, so this happens even if clickhouse-operator is not deployed through the dashboard.
+// It stops when c is closed.  k must be the cluster's root instance.
+func (k *K8s) watchForCHICRD(c *Cache) {
+	ticker := time.NewTicker(crdCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+		}
+		k.lock.RLock()
+		_, cached := c.resources[ResourceCHIs]
+		installed := !cached && chiCRDInstalled(k)
+		k.lock.RUnlock()
+		if cached {
+			return
+		}
+		if installed {
+			k.lock.Lock()
+			if k.cache == c {
+				c.addCHIs(k)
+				c.start()
+			}
+			k.lock.Unlock()
+			return
+		}
+	}
+}
+
+// addFiltered creates an informer for the objects of a resource that have the given label, with the given
+// value unless value is empty
+func (c *Cache) addFiltered(resource string, example runtime.Object, client cache.Getter, label string, value string) {
+	selector := label
+	if value != "" {
+		selector = label + "=" + value
+	}
+	cr := c.add(resource, example, cache.NewFilteredListWatchFromClient(client, resource, "",
+		func(options *metav1.ListOptions) {
+			options.LabelSelector = selector
+		}))
+	cr.requiredLabel = label
+	cr.requiredValue = value
+}
+
+// add creates an informer for a resource, recording the result of each attempt to list or watch it
+func (c *Cache) add(resource string, example runtime.Object, lw *cache.ListWatch) *cachedResource {
+	cr := &cachedResource{}
+	listFunc, watchFunc := lw.ListFunc, lw.WatchFunc
+	lw.ListFunc = func(options metav1.ListOptions) (runtime.Object, error) {
+		obj, err := listFunc(options)
+		cr.record(err)
+		return obj, err
+	}
+	lw.WatchFunc = func(options metav1.ListOptions) (watch.Interface, error) {
+		w, err := watchFunc(options)
+		cr.record(err)
+		return w, err
+	}
+	// Periodic resyncs are not needed, since the cache only serves reads, and would make events look like
+	// contact with the API server
+	cr.informer = cache.NewSharedIndexInformer(lw, example, 0,
+		cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	_ = cr.informer.SetWatchErrorHandler(func(_ *cache.Reflector, err error) {
+		cr.record(err)
+	})
+	cr.informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(interface{}) { cr.record(nil) },
+		UpdateFunc: func(interface{}, interface{}) { cr.record(nil) },
+		DeleteFunc: func(interface{}) { cr.record(nil) },
+	})
+	c.resources[resource] = cr
+	return cr
+}
+
+// record notes the result of communicating with the API server
+func (cr *cachedResource) record(err error) {
+	cr.lock.Lock()
+	defer cr.lock.Unlock()
+	cr.lastError = err
+	if err == nil {
+		cr.updated = time.Now()
+	}
+}
+
+// start runs the informers that are not already running until the cache is stopped.  The caller must hold
+// the write lock.
+func (c *Cache) start() {
+	for _, cr := range c.resources {
+		if !cr.started {
+			cr.started = true
+			go cr.informer.Run(c.stop)
+		}
+	}
+}
+
+// close stops the informers
+func (c *Cache) close() {
+	close(c.stop)
+}
+
+// waitForSync waits until every informer has synced, or the deadline passes.  It logs the resources that did
+// not sync in time, which continue to be read from the API server until they do.
+func (c *Cache) waitForSync(name string, deadline time.Time) {
+	stop := make(chan struct{})
+	timer := time.AfterFunc(time.Until(deadline), func() { close(stop) })
+	defer timer.Stop()
+	for resource, cr := range c.resources {
+		if !cache.WaitForCacheSync(stop, cr.informer.HasSynced) {
+			slog.Warn("Cache did not sync in time, reading from the API server until it does",
+				slog.String("cluster", name), slog.String("resource", resource))
+		}
+	}
+}
+
+// List returns the cached objects of a resource in a namespace (or all namespaces, if namespace is empty)
+// that match the selector.  ok is false if the cache is nil, the resource has not synced, or the selector
+// could match objects that are not cached, in which case the caller should read from the API server.
+func (c *Cache) List(resource string, namespace string, selector labels.Selector) (objs []interface{}, ok bool) {
+	if c == nil {
+		return nil, false
+	}
+	cr, exists := c.resources[resource]
+	if !exists || !cr.informer.HasSynced() {
+		return nil, false
+	}
+	if cr.requiredLabel != "" {
+		if selector == nil {
+			return nil, false
+		}
+		value, found := selector.RequiresExactMatch(cr.requiredLabel)
+		if !found || (cr.requiredValue != "" && value != cr.requiredValue) {
+			return nil, false
+		}
+	}
+	var all []interface{}
+	if namespace == "" {
+		all = cr.informer.GetStore().List()
+	} else {
+		var err error
+		all, err = cr.informer.GetIndexer().ByIndex(cache.NamespaceIndex, namespace)
+		if err != nil {
+			return nil, false
+		}
+	}
+	objs = make([]interface{}, 0, len(all))
+	metas := make([]metav1.Object, 0, len(all))
+	for _, obj := range all {
+		m, err := meta.Accessor(obj)
+		if err != nil {
+			continue
+		}
+		if selector == nil || selector.Matches(labels.Set(m.GetLabels())) {
+			objs = append(objs, obj)
+			metas = append(metas, m)
+		}
+	}
+	// Informer stores are unordered, so sort to match the order the API server lists in
+	sort.Sort(byNamespaceAndName{objs, metas})
+	return objs, true
+}
+
+// Get returns a cached object.  ok is false if the cache is nil, the resource has not synced, or the object
+// is not in the cache, in which case the caller should read from the API server.
+func (c *Cache) Get(resource string, namespace string, name string) (obj interface{}, ok bool) {
+	if c == nil {
+		return nil, false
+	}
+	cr, exists := c.resources[resource]
+	if !exists || !cr.informer.HasSynced() {
+		return nil, false
+	}
+	key := name
+	if namespace != "" {
+		key = namespace + "/" + name
+	}
+	obj, exists, err := cr.informer.GetStore().GetByKey(key)
+	if err != nil || !exists {
+		return nil, false
+	}
+	return obj, true
+}
+
+// Status returns how current the cached copy of each resource is
+func (c *Cache) Status() []CacheStatus {
+	if c == nil {
+		return nil
+	}
+	list := make([]CacheStatus, 0, len(c.resources))
+	for resource, cr := range c.resources {
+		cr.lock.Lock()
+		cs := CacheStatus{
+			Resource: resource,
+			Synced:   cr.informer.HasSynced(),
+			Updated:  cr.updated,
+		}
+		if cr.lastError != nil {
+			cs.Error = cr.lastError.Error()
+		}
+		cr.lock.Unlock()
+		cs.Stale = !cs.Synced || cs.Error != ""
+		list = append(list, cs)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Resource < list[j].Resource
+	})
+	return list
+}
+
+// byNamespaceAndName sorts a list of objects the way the API server does
+type byNamespaceAndName struct {
+	objs  []interface{}
+	metas []metav1.Object
+}
+
+func (b byNamespaceAndName) Len() int { return len(b.objs) }
+
+func (b byNamespaceAndName) Swap(i, j int) {
+	b.objs[i], b.objs[j] = b.objs[j], b.objs[i]
+	b.metas[i], b.metas[j] = b.metas[j], b.metas[i]
+}
+
+func (b byNamespaceAndName) Less(i, j int) bool {
+	if b.metas[i].GetNamespace() != b.metas[j].GetNamespace() {
+		return b.metas[i].GetNamespace() < b.metas[j].GetNamespace()
+	}
+	return b.metas[i].GetName() < b.metas[j].GetName()
+}
@@ -0,0 +1,87 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+)
+
+// numbered returns n distinct lines, the ith being i x's, with the lines given in replace changed
+func numbered(n int, replace map[int]string) string {
+	var sb strings.Builder
+	for i := 1; i <= n; i++ {
+		line, ok := replace[i]
+		if !ok {
+			line = strings.Repeat("x", i)
+		}
+		sb.WriteString(line + "\n")
+	}
+	return sb.String()
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	tests := []struct {
+		name string
+		from string
+		to   string
+		want string
+	}{
+		{
+			name: "same",
+			from: numbered(5, nil),
+			to:   numbered(5, nil),
+			want: "",
+		},
+		{
+			name: "change with context on both sides",
+			from: numbered(10, nil),
+			to:   numbered(10, map[int]string{5: "five"}),
+			want: "--- a\n+++ b\n@@ -2,7 +2,7 @@\n xx\n xxx\n xxxx\n-xxxxx\n+five\n xxxxxx\n xxxxxxx\n xxxxxxxx\n",
+		},
+		{
+			name: "context cut short at the start and end",
+			from: "a\nb\nc\n",
+			to:   "a\nB\nc\n",
+			want: "--- a\n+++ b\n@@ -1,3 +1,3 @@\n a\n-b\n+B\n c\n",
+		},
+		{
+			name: "nearby changes share a hunk",
+			from: numbered(12, nil),
+			to:   numbered(12, map[int]string{3: "three", 9: "nine"}),
+			want: "--- a\n+++ b\n@@ -1,12 +1,12 @@\n x\n xx\n-xxx\n+three\n xxxx\n xxxxx\n xxxxxx\n xxxxxxx\n" +
+				" xxxxxxxx\n-xxxxxxxxx\n+nine\n xxxxxxxxxx\n xxxxxxxxxxx\n xxxxxxxxxxxx\n",
+		},
+		{
+			name: "distant changes get separate hunks",
+			from: numbered(14, nil),
+			to:   numbered(14, map[int]string{2: "two", 12: "twelve"}),
+			want: "--- a\n+++ b\n@@ -1,5 +1,5 @@\n x\n-xx\n+two\n xxx\n xxxx\n xxxxx\n" +
+				"@@ -9,6 +9,6 @@\n xxxxxxxxx\n xxxxxxxxxx\n xxxxxxxxxxx\n-xxxxxxxxxxxx\n+twelve\n xxxxxxxxxxxxx\n xxxxxxxxxxxxxx\n",
+		},
+		{
+			name: "lines added and removed",
+			from: "a\nb\nc\nd\n",
+			to:   "a\nc\nc2\nd\n",
+			want: "--- a\n+++ b\n@@ -1,4 +1,4 @@\n a\n-b\n c\n+c2\n d\n",
+		},
+		{
+			name: "from empty",
+			from: "",
+			to:   "a\nb\n",
+			want: "--- a\n+++ b\n@@ -0,0 +1,2 @@\n+a\n+b\n",
+		},
+		{
+			name: "to empty",
+			from: "a\n",
+			to:   "",
+			want: "--- a\n+++ b\n@@ -1,1 +0,0 @@\n-a\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := UnifiedDiff("a", "b", tt.from, tt.to)
+			if got != tt.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"github.com/altinity/altinity-dashboard/internal/metrics"
 	chopclientset "github.com/altinity/clickhouse-operator/pkg/client/clientset/versioned"
 	errors2 "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/meta"
@@ -18,13 +20,17 @@ import (
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/restmapper"
 	"k8s.io/client-go/tools/clientcmd"
-	"k8s.io/client-go/util/homedir"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	"net/http"
 	"path/filepath"
 	"strings"
 	"sync"
+	"time"
 )
 
 type K8s struct {
+	// Name is the name of the cluster in the dashboard
+	Name            string
 	Config          *rest.Config
 	Clientset       *kubernetes.Clientset
 	ChopClientset   *chopclientset.Clientset
@@ -32,44 +38,175 @@ type K8s struct {
 	RESTMapper      *restmapper.DeferredDiscoveryRESTMapper
 	DynamicClient   dynamic.Interface
 	lock            *sync.RWMutex
+	// source is where the configuration was loaded from, shared with any impersonating instances
+	source *kubeconfigSource
+	// root is the cluster's own instance, which impersonating instances are derived from
+	root *K8s
+	// useCache is set on root instances whose reads are served from an informer cache
+	useCache bool
+	cache    *Cache
+	// generation counts the times the clients have been rebuilt
+	generation uint64
+	// reinitialized is closed when the cluster's root instance is next reinitialized
+	reinitialized chan struct{}
 }
 
 type SelectorFunc func([]*unstructured.Unstructured) []*unstructured.Unstructured
 
+// ClusterSpec identifies a Kubernetes cluster to connect to
+type ClusterSpec struct {
+	Name       string
+	Kubeconfig string
+	Context    string
+}
+
+// DefaultClusterName is the name given to the cluster when only one is configured
+const DefaultClusterName = "default"
+
+var ErrUnknownCluster = errors.New("unknown cluster")
+var ErrDuplicateCluster = errors.New("cluster names must be unique")
+var ErrUnknownContext = errors.New("no such context in the kubeconfig")
+
+// globalK8s is the default cluster, used by requests that do not name a cluster
 var globalK8s *K8s
 
-func InitK8s(kubeconfig string) error {
-	var config *rest.Config
-	var err error
+// clusters holds the Kubernetes instance for each configured cluster, by name
+var clusters map[string]*K8s
+
+// clusterNames lists the configured clusters in the order they were given
+var clusterNames []string
+
+// CacheOptions controls the informer cache that read requests are served from
+type CacheOptions struct {
+	// Enabled turns on the cache
+	Enabled bool
+	// SyncTimeout is how long to wait at startup for the caches of all clusters to sync
+	SyncTimeout time.Duration
+}
 
-	if kubeconfig == "" {
-		config, err = rest.InClusterConfig()
+// InitK8s connects to a single cluster, using the given kubeconfig file and context
+func InitK8s(kubeconfig string, context string, cacheOpts CacheOptions) error {
+	return InitClusters([]ClusterSpec{{Name: DefaultClusterName, Kubeconfig: kubeconfig, Context: context}},
+		cacheOpts)
+}
+
+// InitClusters connects to each of the given clusters.  The first one becomes the default cluster.  If the
+// cache is enabled, InitClusters waits up to cacheOpts.SyncTimeout for it to sync.
+func InitClusters(specs []ClusterSpec, cacheOpts CacheOptions) error {
+	newClusters := make(map[string]*K8s)
+	names := make([]string, 0, len(specs))
+	for _, spec := range specs {
+		if _, ok := newClusters[spec.Name]; ok {
+			return fmt.Errorf("%w: %s", ErrDuplicateCluster, spec.Name)
+		}
+		src := &kubeconfigSource{
+			kubeconfig: spec.Kubeconfig,
+			context:    spec.Context,
+		}
+		config, err := src.load()
 		if err != nil {
-			home := homedir.HomeDir()
-			if home != "" {
-				config, err = clientcmd.BuildConfigFromFlags("", filepath.Join(home, ".kube", "config"))
-			}
+			return fmt.Errorf("cluster %s: %w", spec.Name, err)
 		}
-	} else {
-		config, err = clientcmd.BuildConfigFromFlags("", kubeconfig)
+		k := &K8s{
+			Name:     spec.Name,
+			Config:   config,
+			lock:     &sync.RWMutex{},
+			source:   src,
+			useCache: cacheOpts.Enabled,
+		}
+		k.root = k
+		err = k.Reinit()
+		if err != nil {
+			return fmt.Errorf("cluster %s: %w", spec.Name, err)
+		}
+		newClusters[spec.Name] = k
+		names = append(names, spec.Name)
 	}
-	if err != nil {
-		return err
+	clusters = newClusters
+	clusterNames = names
+	if len(names) > 0 {
+		globalK8s = newClusters[names[0]]
 	}
+	if cacheOpts.Enabled {
+		deadline := time.Now().Add(cacheOpts.SyncTimeout)
+		for _, name := range names {
+			newClusters[name].cache.waitForSync(name, deadline)
+		}
+	}
+	return nil
+}
 
-	globalK8s = &K8s{
-		Config: config,
-		lock:   &sync.RWMutex{},
+// Cache returns the informer cache of the cluster, or nil if reads are not cached.  The methods of Cache
+// can be called on a nil Cache, and report that the caller should read from the API server instead.
+func (k *K8s) Cache() *Cache {
+	return k.cache
+}
+
+// loadingRules returns the rules for finding kubeconfig files.  kubeconfig may list several files, separated
+// as in $KUBECONFIG, which are merged.  If it is empty, the files in $KUBECONFIG or ~/.kube/config are used.
+func loadingRules(kubeconfig string) *clientcmd.ClientConfigLoadingRules {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	files := filepath.SplitList(kubeconfig)
+	switch {
+	case len(files) == 1:
+		rules.ExplicitPath = files[0]
+	case len(files) > 1:
+		rules.Precedence = files
+	}
+	return rules
+}
+
+// Kubeconfig returns the merged kubeconfig the cluster was loaded from, and the name of the context in use.
+// The kubeconfig is empty if the in-cluster configuration is in use.
+func (k *K8s) Kubeconfig() (*clientcmdapi.Config, string, error) {
+	kubeconfig, context, inCluster := k.source.get()
+	if inCluster {
+		return clientcmdapi.NewConfig(), "", nil
+	}
+	raw, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules(kubeconfig), nil).RawConfig()
+	if err != nil {
+		return nil, "", err
+	}
+	if context == "" {
+		context = raw.CurrentContext
 	}
-	err = globalK8s.Reinit()
+	return &raw, context, nil
+}
+
+// UseContext switches the cluster to a different context in its kubeconfig, and rebuilds the clients.  The
+// caller must not hold an open GetK8s() reference.
+func (k *K8s) UseContext(context string) error {
+	raw, _, err := k.Kubeconfig()
 	if err != nil {
 		return err
 	}
+	if _, ok := raw.Contexts[context]; !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownContext, context)
+	}
+	k.source.setContext(context)
+	return k.Reload()
+}
 
-	return nil
+// ClusterNames returns the names of the configured clusters, starting with the default cluster
+func ClusterNames() []string {
+	return clusterNames
+}
+
+// DefaultCluster returns the name of the default cluster
+func DefaultCluster() string {
+	if len(clusterNames) == 0 {
+		return ""
+	}
+	return clusterNames[0]
+}
+
+// HasCluster reports whether a cluster with the given name is configured
+func HasCluster(name string) bool {
+	_, ok := clusters[name]
+	return ok
 }
 
-// GetK8s gets a reference to the global Kubernetes instance.  The caller must call ReleaseK8s.
+// GetK8s gets a reference to the default Kubernetes instance.  The caller must call ReleaseK8s.
 func GetK8s() *K8s {
 	if globalK8s == nil {
 		panic("GetK8s called before InitK8s")
@@ -78,6 +215,83 @@ func GetK8s() *K8s {
 	return globalK8s
 }
 
+// GetClusterK8s gets a reference to the Kubernetes instance for the named cluster, or the default cluster
+// if name is empty.  The caller must call ReleaseK8s.
+func GetClusterK8s(name string) (*K8s, error) {
+	if name == "" {
+		return GetK8s(), nil
+	}
+	k, ok := clusters[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownCluster, name)
+	}
+	k.lock.RLock()
+	return k, nil
+}
+
+// impersonatedK8s is a cached K8s instance that acts as a particular user
+type impersonatedK8s struct {
+	k *K8s
+	// generation is the generation of the cluster's root instance that k was built from
+	generation uint64
+}
+
+var impersonated = make(map[string]*impersonatedK8s)
+var impersonatedLock sync.Mutex
+
+// GetK8sAs gets a reference to a Kubernetes instance that impersonates the given user and groups, so that
+// the API server authorizes requests as that user.  The caller must call ReleaseK8s.
+func GetK8sAs(userName string, groups []string) (*K8s, error) {
+	return GetClusterK8sAs("", userName, groups)
+}
+
+// GetClusterK8sAs is like GetK8sAs, but for the named cluster, or the default cluster if name is empty
+func GetClusterK8sAs(name string, userName string, groups []string) (*K8s, error) {
+	if name == "" {
+		name = DefaultCluster()
+	}
+	k, err := GetClusterK8s(name)
+	if err != nil {
+		return nil, err
+	}
+	// Copy the configuration while holding the reference, since Reload replaces it
+	config := rest.CopyConfig(k.Config)
+	generation := k.generation
+	reinitialized := k.reinitialized
+	k.ReleaseK8s()
+
+	impersonatedLock.Lock()
+	defer impersonatedLock.Unlock()
+	key := name + "\x00" + userName + "\x00" + strings.Join(groups, "\x00")
+	ik, ok := impersonated[key]
+	if !ok || ik.generation != generation {
+		// Build a new instance if there isn't one, or if the cluster has been reinitialized since it was built,
+		// for example because its kubeconfig was reloaded or a CRD was installed
+		config.Impersonate = rest.ImpersonationConfig{
+			UserName: userName,
+			Groups:   groups,
+		}
+		ik = &impersonatedK8s{
+			k: &K8s{
+				Name:          k.Name,
+				Config:        config,
+				lock:          &sync.RWMutex{},
+				source:        k.source,
+				root:          k,
+				reinitialized: reinitialized,
+			},
+			generation: generation,
+		}
+		err := ik.k.Reinit()
+		if err != nil {
+			return nil, err
+		}
+		impersonated[key] = ik
+	}
+	ik.k.lock.RLock()
+	return ik.k, nil
+}
+
 // ReleaseK8s releases the reference held by the caller.
 func (k *K8s) ReleaseK8s() {
 	k.lock.RUnlock()
@@ -87,34 +301,80 @@ func (k *K8s) ReleaseK8s() {
 func (k *K8s) Reinit() error {
 	k.lock.Lock()
 	defer k.lock.Unlock()
+	metrics.Reinits.Inc()
+	k.generation++
+	if k.root == k {
+		if k.reinitialized != nil {
+			close(k.reinitialized)
+		}
+		k.reinitialized = make(chan struct{})
+	}
+
+	// Retry requests rejected as unauthorized with freshly loaded credentials.  The wrapper is set on a copy,
+	// since k.Config is read by callers holding only a reference.
+	config := rest.CopyConfig(k.Config)
+	config.WrapTransport = func(rt http.RoundTripper) http.RoundTripper {
+		return &unauthorizedRetrier{k: k, impersonate: config.Impersonate, rt: rt}
+	}
 
 	var err error
 
-	k.Clientset, err = kubernetes.NewForConfig(k.Config)
+	k.Clientset, err = kubernetes.NewForConfig(config)
 	if err != nil {
 		return err
 	}
 
-	k.ChopClientset, err = chopclientset.NewForConfig(k.Config)
+	k.ChopClientset, err = chopclientset.NewForConfig(config)
 	if err != nil {
 		return err
 	}
 
-	k.DiscoveryClient, err = discovery.NewDiscoveryClientForConfig(k.Config)
+	k.DiscoveryClient, err = discovery.NewDiscoveryClientForConfig(config)
 	if err != nil {
 		return err
 	}
 
 	k.RESTMapper = restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(k.DiscoveryClient))
 
-	k.DynamicClient, err = dynamic.NewForConfig(k.Config)
+	k.DynamicClient, err = dynamic.NewForConfig(config)
 	if err != nil {
 		return err
 	}
 
+	// Rebuild the cache if the configuration has changed, so it uses the new credentials.  Otherwise, start
+	// caching ClickHouseInstallations if their CRD has been installed since the cache was built.
+	if k.useCache {
+		if k.cache == nil || k.cache.config != k.Config {
+			if k.cache != nil {
+				k.cache.close()
+			}
+			k.cache = newCache(k)
+			if _, cached := k.cache.resources[ResourceCHIs]; !cached {
+				go k.watchForCHICRD(k.cache)
+			}
+		} else {
+			k.cache.addCHIs(k)
+		}
+		k.cache.start()
+	}
+
 	return nil
 }
 
+// Reinitialized returns a channel that is closed when the cluster is next reinitialized, for example because
+// its kubeconfig was reloaded or its context changed.  Clients taken from k should not be used after that.
+func (k *K8s) Reinitialized() <-chan struct{} {
+	return k.reinitialized
+}
+
+// ReinitHeld reinitializes Kubernetes on behalf of a caller that holds a GetK8s() reference.  The reference
+// is temporarily released, and is held again when ReinitHeld returns.
+func (k *K8s) ReinitHeld() error {
+	k.lock.RUnlock()
+	defer k.lock.RLock()
+	return k.Reinit()
+}
+
 var decUnstructured = yaml.NewDecodingSerializer(unstructured.UnstructuredJSONScheme)
 
 func DecodeYAMLToObject(yaml string) (*unstructured.Unstructured, error) {
@@ -130,34 +390,40 @@ var fieldManagerName = "altinity-dashboard"
 var ErrNoNamespace = errors.New("could not determine namespace for namespace-scoped entity")
 var ErrNamespaceConflict = errors.New("provided namespace conflicts with YAML object")
 
-// doApplyWithSSA does a server-side apply of an object
-func doApplyWithSSA(dr dynamic.ResourceInterface, obj *unstructured.Unstructured) error {
+// dryRunOption returns the DryRun field of the request options, which makes the API server validate and
+// admit a change without persisting it
+func dryRunOption(dryRun bool) []string {
+	if dryRun {
+		return []string{metav1.DryRunAll}
+	}
+	return nil
+}
+
+// doApplyWithSSA does a server-side apply of an object, and returns the resulting object
+func doApplyWithSSA(dr dynamic.ResourceInterface, obj *unstructured.Unstructured, dryRun bool) (*unstructured.Unstructured, error) {
 	// Marshal object into JSON
 	data, err := json.Marshal(obj)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// Create or Update the object with SSA
 	force := true
-	_, err = dr.Patch(context.TODO(), obj.GetName(), types.ApplyPatchType, data, metav1.PatchOptions{
+	return dr.Patch(context.TODO(), obj.GetName(), types.ApplyPatchType, data, metav1.PatchOptions{
 		FieldManager: fieldManagerName,
 		Force:        &force,
+		DryRun:       dryRunOption(dryRun),
 	})
-	if err != nil {
-		return err
-	}
-	return nil
 }
 
-// doGetVerUpdate does a client-side apply of an object
-func doGetVerUpdate(dr dynamic.ResourceInterface, obj *unstructured.Unstructured) error {
+// doGetVerUpdate does a client-side apply of an object, and returns the resulting object
+func doGetVerUpdate(dr dynamic.ResourceInterface, obj *unstructured.Unstructured, dryRun bool) (*unstructured.Unstructured, error) {
 	// Retrieve current object from Kubernetes
 	curObj, err := dr.Get(context.TODO(), obj.GetName(), metav1.GetOptions{})
 	if err != nil {
 		se := &errors2.StatusError{}
 		if !errors.As(err, &se) || se.ErrStatus.Code != 404 {
-			return err
+			return nil, err
 		}
 	}
 
@@ -165,27 +431,20 @@ func doGetVerUpdate(dr dynamic.ResourceInterface, obj *unstructured.Unstructured
 	if err == nil {
 		// If the old object existed, copy its version number to the new object
 		obj.SetResourceVersion(curObj.GetResourceVersion())
-		_, err = dr.Update(context.TODO(), obj, metav1.UpdateOptions{
+		return dr.Update(context.TODO(), obj, metav1.UpdateOptions{
 			FieldManager: fieldManagerName,
+			DryRun:       dryRunOption(dryRun),
 		})
-		if err != nil {
-			return err
-		}
-	} else {
-		_, err = dr.Create(context.TODO(), obj, metav1.CreateOptions{
-			FieldManager: fieldManagerName,
-		})
-	}
-	if err != nil {
-		return err
 	}
-	return nil
+	return dr.Create(context.TODO(), obj, metav1.CreateOptions{
+		FieldManager: fieldManagerName,
+		DryRun:       dryRunOption(dryRun),
+	})
 }
 
-// getDynamicREST gets a dynamic REST interface for a given unstructured object
+// getDynamicREST gets a dynamic REST interface for a given unstructured object.  The caller must hold a
+// GetK8s() reference.
 func (k *K8s) getDynamicRest(obj *unstructured.Unstructured, namespace string) (dynamic.ResourceInterface, string, error) {
-	k.lock.RLock()
-	defer k.lock.RUnlock()
 
 	gvk := obj.GroupVersionKind()
 	var mapping *meta.RESTMapping
@@ -219,16 +478,16 @@ func (k *K8s) getDynamicRest(obj *unstructured.Unstructured, namespace string) (
 	return dr, finalNamespace, nil
 }
 
-// doApplyOrDelete does an apply or delete of a given YAML string
+// doApplyOrDelete does an apply or delete of a given YAML string, and returns the applied objects
 // Adapted from https://ymmt2005.hatenablog.com/entry/2020/04/14/An_example_of_using_dynamic_client_of_k8s.io/client-go
-func (k *K8s) doApplyOrDelete(yaml string, namespace string, doDelete bool, useSSA bool, selector SelectorFunc) error {
-	k.lock.RLock()
-	defer k.lock.RUnlock()
+// The caller must hold a GetK8s() reference.
+func (k *K8s) doApplyOrDelete(yaml string, namespace string, doDelete bool, useSSA bool, selector SelectorFunc,
+	dryRun bool) ([]*unstructured.Unstructured, error) {
 
 	// Split YAML into individual docs
 	yamlDocs, err := SplitYAMLDocs(yaml)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// Parse YAML documents into objects
@@ -237,7 +496,7 @@ func (k *K8s) doApplyOrDelete(yaml string, namespace string, doDelete bool, useS
 		var obj *unstructured.Unstructured
 		obj, err = DecodeYAMLToObject(yd)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		candidates = append(candidates, obj)
 	}
@@ -247,6 +506,7 @@ func (k *K8s) doApplyOrDelete(yaml string, namespace string, doDelete bool, useS
 		candidates = selector(candidates)
 	}
 
+	results := make([]*unstructured.Unstructured, 0, len(candidates))
 	for _, obj := range candidates {
 		var dr dynamic.ResourceInterface
 		var finalNamespace string
@@ -256,9 +516,10 @@ func (k *K8s) doApplyOrDelete(yaml string, namespace string, doDelete bool, useS
 			continue
 		}
 
+		var result *unstructured.Unstructured
 		switch {
 		case doDelete:
-			err = dr.Delete(context.TODO(), obj.GetName(), metav1.DeleteOptions{})
+			err = dr.Delete(context.TODO(), obj.GetName(), metav1.DeleteOptions{DryRun: dryRunOption(dryRun)})
 			var se *errors2.StatusError
 			if errors.As(err, &se) {
 				if se.Status().Reason == metav1.StatusReasonNotFound {
@@ -267,38 +528,44 @@ func (k *K8s) doApplyOrDelete(yaml string, namespace string, doDelete bool, useS
 				}
 			}
 		case !doDelete && useSSA:
-			err = doApplyWithSSA(dr, obj)
+			result, err = doApplyWithSSA(dr, obj, dryRun)
 		case !doDelete && !useSSA:
-			err = doGetVerUpdate(dr, obj)
+			result, err = doGetVerUpdate(dr, obj, dryRun)
 		}
 		if err != nil {
-			return err
+			return nil, err
+		}
+		if result != nil {
+			results = append(results, result)
 		}
 	}
-	return nil
+	return results, nil
 }
 
-// MultiYamlApply does a server-side apply of a given YAML string, which may contain multiple documents
-func (k *K8s) MultiYamlApply(yaml string, namespace string) error {
-	return k.doApplyOrDelete(yaml, namespace, false, true, nil)
+// MultiYamlApply does a server-side apply of a given YAML string, which may contain multiple documents, and
+// returns the resulting objects.  If dryRun is set, the API server validates the changes without persisting them.
+func (k *K8s) MultiYamlApply(yaml string, namespace string, dryRun bool) ([]*unstructured.Unstructured, error) {
+	return k.doApplyOrDelete(yaml, namespace, false, true, nil, dryRun)
 }
 
 // MultiYamlApplySelectively does a selective server-side apply of multiple docs from a given YAML string
-func (k *K8s) MultiYamlApplySelectively(yaml string, namespace string, selector SelectorFunc) error {
-	return k.doApplyOrDelete(yaml, namespace, false, true, selector)
+func (k *K8s) MultiYamlApplySelectively(yaml string, namespace string, selector SelectorFunc,
+	dryRun bool) ([]*unstructured.Unstructured, error) {
+	return k.doApplyOrDelete(yaml, namespace, false, true, selector, dryRun)
 }
 
 // MultiYamlDelete deletes the resources identified in a given YAML string
 func (k *K8s) MultiYamlDelete(yaml string, namespace string) error {
-	return k.doApplyOrDelete(yaml, namespace, true, false, nil)
+	_, err := k.doApplyOrDelete(yaml, namespace, true, false, nil, false)
+	return err
 }
 
 var ErrOperatorNotDeployed = errors.New("the ClickHouse Operator is not fully deployed")
 
-// singleYamlCreateOrUpdate creates or updates a new resource from a single YAML spec
-func (k *K8s) singleYamlCreateOrUpdate(obj *unstructured.Unstructured, namespace string, doCreate bool) error {
-	k.lock.RLock()
-	defer k.lock.RUnlock()
+// singleYamlCreateOrUpdate creates or updates a new resource from a single YAML spec, and returns the resulting
+// object.  The caller must hold a GetK8s() reference.
+func (k *K8s) singleYamlCreateOrUpdate(obj *unstructured.Unstructured, namespace string, doCreate bool,
+	dryRun bool) (*unstructured.Unstructured, error) {
 
 	gdr := func() (dynamic.ResourceInterface, error) {
 		dr, _, err := k.getDynamicRest(obj, namespace)
@@ -317,39 +584,36 @@ func (k *K8s) singleYamlCreateOrUpdate(obj *unstructured.Unstructured, namespace
 	if errors.Is(err, ErrOperatorNotDeployed) {
 		// Before returning ErrOperatorNotDeployed, try reinitializing the K8s client, which may
 		// be holding old information in its cache.  (For example, it may not know about a CRD.)
-		k.lock.RUnlock()
-		err = k.Reinit()
-		k.lock.RLock()
+		err = k.ReinitHeld()
 		if err != nil {
-			return err
+			return nil, err
 		}
 		dr, err = gdr()
 	}
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	if doCreate {
-		_, err = dr.Create(context.TODO(), obj, metav1.CreateOptions{
+		return dr.Create(context.TODO(), obj, metav1.CreateOptions{
 			FieldManager: fieldManagerName,
+			DryRun:       dryRunOption(dryRun),
 		})
-	} else {
-		_, err = dr.Update(context.TODO(), obj, metav1.UpdateOptions{
-			FieldManager: fieldManagerName,
-		})
-	}
-	if err != nil {
-		return err
 	}
-	return nil
+	return dr.Update(context.TODO(), obj, metav1.UpdateOptions{
+		FieldManager: fieldManagerName,
+		DryRun:       dryRunOption(dryRun),
+	})
 }
 
-// SingleObjectCreate creates a new resource from a single unstructured object
-func (k *K8s) SingleObjectCreate(obj *unstructured.Unstructured, namespace string) error {
-	return k.singleYamlCreateOrUpdate(obj, namespace, true)
+// SingleObjectCreate creates a new resource from a single unstructured object, and returns the resulting
+// object.  If dryRun is set, the API server validates the change without persisting it.
+func (k *K8s) SingleObjectCreate(obj *unstructured.Unstructured, namespace string, dryRun bool) (*unstructured.Unstructured, error) {
+	return k.singleYamlCreateOrUpdate(obj, namespace, true, dryRun)
 }
 
-// SingleObjectUpdate updates an existing object from a single unstructured object
-func (k *K8s) SingleObjectUpdate(obj *unstructured.Unstructured, namespace string) error {
-	return k.singleYamlCreateOrUpdate(obj, namespace, false)
+// SingleObjectUpdate updates an existing object from a single unstructured object, and returns the resulting
+// object.  If dryRun is set, the API server validates the change without persisting it.
+func (k *K8s) SingleObjectUpdate(obj *unstructured.Unstructured, namespace string, dryRun bool) (*unstructured.Unstructured, error) {
+	return k.singleYamlCreateOrUpdate(obj, namespace, false, dryRun)
 }
@@ -0,0 +1,240 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// retryInterval limits how often a cluster's requests that were rejected as unauthorized are retried, so
+// that credentials which are simply wrong don't double the load on the API server
+const retryInterval = 10 * time.Second
+
+// kubeconfigSource is where a cluster's configuration is loaded from, and the state of the last load
+type kubeconfigSource struct {
+	lock       sync.Mutex
+	kubeconfig string
+	context    string
+	inCluster  bool
+	data       []byte
+	loaded     time.Time
+	lastError  error
+	lastRetry  time.Time
+}
+
+// KubeconfigStatus describes when a cluster's kubeconfig was last loaded and the result of the last attempt
+type KubeconfigStatus struct {
+	Loaded time.Time `json:"loaded" description:"time the kubeconfig was last loaded"`
+	Error  string    `json:"error,omitempty" description:"error from the last reload attempt, if it failed"`
+}
+
+// get returns the kubeconfig files, the context and whether the in-cluster configuration is in use
+func (s *kubeconfigSource) get() (string, string, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.kubeconfig, s.context, s.inCluster
+}
+
+// setContext changes the context that is used the next time the configuration is loaded
+func (s *kubeconfigSource) setContext(context string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.context = context
+}
+
+// build builds a client configuration from the source.  If no kubeconfig or context was given and
+// $KUBECONFIG is not set, the in-cluster configuration is used if available, in which case inCluster is true.
+func (s *kubeconfigSource) build() (config *rest.Config, inCluster bool, err error) {
+	kubeconfig, context, _ := s.get()
+	if kubeconfig == "" && context == "" && os.Getenv(clientcmd.RecommendedConfigPathEnvVar) == "" {
+		config, err = rest.InClusterConfig()
+		if err == nil {
+			return config, true, nil
+		}
+	}
+	config, err = clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+		loadingRules(kubeconfig),
+		&clientcmd.ConfigOverrides{CurrentContext: context},
+	).ClientConfig()
+	return config, false, err
+}
+
+// load builds a client configuration from the source, and records the result
+func (s *kubeconfigSource) load() (*rest.Config, error) {
+	data := s.read()
+	config, inCluster, err := s.build()
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.lastError = err
+	if err != nil {
+		return nil, err
+	}
+	s.inCluster = inCluster
+	s.data = data
+	s.loaded = time.Now()
+	return config, nil
+}
+
+// read returns the combined contents of the kubeconfig files, for detecting changes.  Missing files are skipped.
+func (s *kubeconfigSource) read() []byte {
+	kubeconfig, _, _ := s.get()
+	var data []byte
+	for _, f := range loadingRules(kubeconfig).GetLoadingPrecedence() {
+		b, err := os.ReadFile(f)
+		if err == nil {
+			data = append(data, b...)
+		}
+	}
+	return data
+}
+
+// changed reports whether the kubeconfig files have changed since they were last loaded
+func (s *kubeconfigSource) changed() bool {
+	data := s.read()
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return !s.inCluster && !bytes.Equal(data, s.data)
+}
+
+// allowRetry reports whether an unauthorized request may be retried now, and if so, records the retry
+func (s *kubeconfigSource) allowRetry() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if time.Since(s.lastRetry) < retryInterval {
+		return false
+	}
+	s.lastRetry = time.Now()
+	return true
+}
+
+// status returns the state of the last load, or nil if the in-cluster configuration is in use
+func (s *kubeconfigSource) status() *KubeconfigStatus {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.inCluster {
+		return nil
+	}
+	ks := &KubeconfigStatus{Loaded: s.loaded}
+	if s.lastError != nil {
+		ks.Error = s.lastError.Error()
+	}
+	return ks
+}
+
+// KubeconfigStatus returns the state of the cluster's kubeconfig, or nil if the in-cluster configuration is in use
+func (k *K8s) KubeconfigStatus() *KubeconfigStatus {
+	return k.source.status()
+}
+
+// Reload reloads the cluster's configuration from its kubeconfig, and rebuilds the clients.  Instances that
+// impersonate users are rebuilt the next time they are used.  The caller must not hold an open GetK8s() reference.
+func (k *K8s) Reload() error {
+	config, err := k.source.load()
+	if err != nil {
+		return err
+	}
+	k.lock.Lock()
+	k.Config = config
+	k.lock.Unlock()
+	return k.Reinit()
+}
+
+// WatchKubeconfigs checks the clusters' kubeconfig files for changes at the given interval until ctx is
+// cancelled, and reloads clusters whose files have changed.  Failed reloads are logged, and the previous
+// configuration continues to be used.
+func WatchKubeconfigs(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		for _, name := range ClusterNames() {
+			k := clusters[name]
+			if !k.source.changed() {
+				continue
+			}
+			err := k.Reload()
+			if err != nil {
+				slog.Error("Error reloading kubeconfig", slog.String("cluster", name), slog.Any("err", err))
+			} else {
+				slog.Info("Reloaded kubeconfig", slog.String("cluster", name))
+			}
+		}
+	}
+}
+
+// unauthorizedRetrier retries requests that the API server rejects as unauthorized, using credentials freshly
+// loaded from the kubeconfig.  If that succeeds, the cluster is reloaded so later requests use them too.
+type unauthorizedRetrier struct {
+	k           *K8s
+	impersonate rest.ImpersonationConfig
+	rt          http.RoundTripper
+}
+
+func (u *unauthorizedRetrier) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := u.rt.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		// The request can't be replayed
+		return resp, err
+	}
+	if !u.k.source.allowRetry() {
+		return resp, err
+	}
+	retryResp, retryErr := u.retry(req)
+	if retryErr != nil {
+		slog.Error("Error retrying unauthorized request", slog.String("cluster", u.k.Name), slog.Any("err", retryErr))
+		return resp, err
+	}
+	_ = resp.Body.Close()
+	if retryResp.StatusCode != http.StatusUnauthorized {
+		go func() {
+			err := u.k.root.Reload()
+			if err != nil {
+				slog.Error("Error reloading kubeconfig", slog.String("cluster", u.k.Name), slog.Any("err", err))
+			} else {
+				slog.Info("Reloaded kubeconfig after an unauthorized request", slog.String("cluster", u.k.Name))
+			}
+		}()
+	}
+	return retryResp, nil
+}
+
+// retry sends the request again using a transport built from a freshly loaded configuration
+func (u *unauthorizedRetrier) retry(req *http.Request) (*http.Response, error) {
+	config, _, err := u.k.source.build()
+	if err != nil {
+		return nil, err
+	}
+	config.Impersonate = u.impersonate
+	rt, err := rest.TransportFor(config)
+	if err != nil {
+		return nil, err
+	}
+	r := req.Clone(req.Context())
+	r.Header.Del("Authorization")
+	for h := range r.Header {
+		if strings.HasPrefix(h, "Impersonate-") {
+			r.Header.Del(h)
+		}
+	}
+	if req.GetBody != nil {
+		r.Body, err = req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return rt.RoundTrip(r)
+}
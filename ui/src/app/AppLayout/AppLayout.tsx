@@ -7,11 +7,13 @@ import {
   NavExpandable,
   Page,
   PageHeader,
+  PageHeaderTools,
   PageSidebar,
   SkipToContent
 } from '@patternfly/react-core';
 import { routes, IAppRoute, IAppRouteGroup } from '@app/routes';
 import logo from '@app/images/altinity_horizontal_logo.png';
+import { ClusterSelector } from '@app/Components/ClusterSelector';
 
 interface IAppLayout {
   children: React.ReactNode
@@ -43,6 +45,7 @@ export const AppLayout: React.FunctionComponent<IAppLayout> = (props) => {
   const Header = (
     <PageHeader
       logo={<LogoImg />}
+      headerTools={<PageHeaderTools><ClusterSelector/></PageHeaderTools>}
       showNavToggle
       isNavOpen={isNavOpen}
       onNavToggle={isMobileView ? onNavToggleMobile : onNavToggle}
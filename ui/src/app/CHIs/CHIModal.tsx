@@ -10,6 +10,7 @@ import {
 } from '@patternfly/react-core';
 import { CodeEditor, Language } from '@patternfly/react-code-editor';
 import { NamespaceSelector } from '@app/Namespaces/NamespaceSelector';
+import { defaultNamespace } from '@app/utils/defaultNamespace';
 import { editor } from 'monaco-editor';
 import IStandaloneCodeEditor = editor.IStandaloneCodeEditor;
 import CodeIcon from '@patternfly/react-icons/dist/esm/icons/code-icon';
@@ -27,13 +28,14 @@ export interface CHIModalProps extends ToggleModalSubProps {
 export const CHIModal: React.FunctionComponent<CHIModalProps> = (props: CHIModalProps) => {
   const { isModalOpen, isUpdate, CHIName, CHINamespace } = props
   const outerCloseModal = props.closeModal
-  const [selectedNamespace, setSelectedNamespace] = useState("")
+  const [selectedNamespace, setSelectedNamespace] = useState(defaultNamespace())
   const [yaml, setYaml] = useState("")
+  const [originalYaml, setOriginalYaml] = useState("")
   const [exampleListValues, setExampleListValues] = useState(new Array<string>())
   const addAlert = useContext(AddAlertContext)
 
   const closeModal = (): void => {
-    setSelectedNamespace("")
+    setSelectedNamespace(defaultNamespace())
     outerCloseModal()
   }
   const setYamlFromEditor = (editor: IStandaloneCodeEditor) => {
@@ -43,15 +45,31 @@ export const CHIModal: React.FunctionComponent<CHIModalProps> = (props: CHIModal
     const [url, method, action] = isUpdate ?
       [`/api/v1/chis/${CHINamespace}/${CHIName}`, 'PATCH', 'updating'] :
       [`/api/v1/chis/${selectedNamespace}`, 'POST', 'creating']
+    // When updating, send the YAML the edit started from, so that changes made by others in the meantime
+    // can be merged with this one if they don't touch the same fields
     fetchWithErrorHandling(url, method,
-      {
+      isUpdate ? {
+        yaml: yaml,
+        original_yaml: originalYaml,
+        merge: true
+      } : {
         yaml: yaml
       },
       () => {
         setYaml("")
       },
       (response, text, error) => {
-        const errorMessage = (error == "") ? text : `${error}: ${text}`
+        let errorMessage = (error == "") ? text : `${error}: ${text}`
+        if (response && response.status === 409) {
+          try {
+            const conflict = JSON.parse(text) as { error: string, conflicts?: Array<{ path: string }> }
+            errorMessage = conflict.conflicts ?
+              `${conflict.error}: ${conflict.conflicts.map(c => c.path).join(", ")}` :
+              conflict.error
+          } catch (e) {
+            // Not a conflict description, so show the response as it is
+          }
+        }
         addAlert(`Error ${action} CHI: ${errorMessage}`, AlertVariant.danger)
       })
     closeModal()
@@ -78,6 +96,7 @@ export const CHIModal: React.FunctionComponent<CHIModalProps> = (props: CHIModal
         (response, body) => {
           if (typeof body === 'object') {
             setYaml((body[0] as CHI).resource_yaml);
+            setOriginalYaml((body[0] as CHI).resource_yaml);
           }
         },
         (response, text, error) => {
@@ -87,6 +106,7 @@ export const CHIModal: React.FunctionComponent<CHIModalProps> = (props: CHIModal
       )
     } else {
       setYaml("")
+      setOriginalYaml("")
     }
     // eslint-disable-next-line react-hooks/exhaustive-deps
   }, [CHIName, CHINamespace, isModalOpen, isUpdate])
@@ -117,7 +137,7 @@ export const CHIModal: React.FunctionComponent<CHIModalProps> = (props: CHIModal
                 <div>
                   Select a Namespace To Deploy To:
                 </div>
-                <NamespaceSelector onSelect={setSelectedNamespace}/>
+                <NamespaceSelector onSelect={setSelectedNamespace} initialSelected={defaultNamespace()}/>
               </React.Fragment>
             )}
           </GridItem>
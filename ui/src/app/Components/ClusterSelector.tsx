@@ -0,0 +1,50 @@
+import * as React from 'react';
+import { useEffect, useState } from 'react';
+import { FormSelect, FormSelectOption } from '@patternfly/react-core';
+import { fetchWithErrorHandling } from '@app/utils/fetchWithErrorHandling';
+import { ClusterInfo, selectedCluster, setSelectedCluster } from '@app/utils/cluster';
+
+// ClusterSelector lets the user switch between clusters.  It is only shown if there is more than one.
+export const ClusterSelector: React.FunctionComponent = () => {
+  const [clusters, setClusters] = useState(new Array<ClusterInfo>())
+  const [current, setCurrent] = useState(selectedCluster())
+  useEffect(() => {
+    fetchWithErrorHandling(`/api/v1/clusters`, 'GET',
+      undefined,
+      (response, body) => {
+        const list = body as ClusterInfo[]
+        if (current && !list.some(c => c.name === current)) {
+          // The remembered cluster is no longer configured, so go back to the default
+          setSelectedCluster("")
+          window.location.reload()
+          return
+        }
+        setClusters(list)
+      },
+      () => {
+        setClusters([])
+      })
+  },
+  // eslint-disable-next-line react-hooks/exhaustive-deps
+  [])
+  if (clusters.length < 2) {
+    return null
+  }
+  const defaultCluster = clusters.find(c => c.default)?.name || ""
+  return (
+    <FormSelect
+      value={current || defaultCluster}
+      aria-label="Cluster"
+      onChange={(value: string) => {
+        // Reload, so that every page fetches its data from the newly selected cluster
+        setCurrent(value)
+        setSelectedCluster(value === defaultCluster ? "" : value)
+        window.location.reload()
+      }}
+    >
+      {clusters.map(c => (
+        <FormSelectOption key={c.name} value={c.name} label={`Cluster: ${c.name}`}/>
+      ))}
+    </FormSelect>
+  )
+}
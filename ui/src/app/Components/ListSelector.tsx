@@ -7,9 +7,10 @@ export const ListSelector: React.FunctionComponent<
     onSelect?: (selected: string) => void
     listValues: string[]
     width?: string
+    initialSelected?: string
   }> = (props) => {
 
-  const [selected, setSelected] = useState("")
+  const [selected, setSelected] = useState(props.initialSelected || "")
   const [searchValue, setSearchValue] = useState("")
   const [filterValue, setFilterValue] = useState("")
   const [isDropDownOpen, setIsDropDownOpen] = useState(false)
@@ -15,6 +15,30 @@ import { useEffect, useRef, useState } from 'react';
 import { ChartDonutUtilization } from '@patternfly/react-charts';
 import { Loading } from '@app/Components/Loading';
 import { usePageVisibility } from 'react-page-visibility';
+import { ExpandableTable, WarningType } from '@app/Components/ExpandableTable';
+
+interface DashboardCluster {
+  name: string
+  kube_cluster: string
+  kube_version: string
+  chop_count: number
+  chop_count_available: number
+  chi_count: number
+  chi_count_complete: number
+  auth_status: string
+  kubeconfig?: {
+    loaded: string
+    error?: string
+  }
+  cache?: Array<{
+    resource: string
+    synced: boolean
+    stale: boolean
+    updated: string
+    error?: string
+  }>
+  error?: string
+}
 
 interface DashboardInfo {
   kube_cluster: string
@@ -23,6 +47,8 @@ interface DashboardInfo {
   chop_count_available: number
   chi_count: number
   chi_count_complete: number
+  auth_status: string
+  clusters: DashboardCluster[]
 }
 
 export const Dashboard: React.FunctionComponent = () => {
@@ -70,6 +96,17 @@ export const Dashboard: React.FunctionComponent = () => {
   const retrieveErrorPane = retrieveError === undefined ? null : (
     <Alert variant="danger" title={retrieveError} isInline/>
   )
+  const unauthorizedClusters = dashboardInfo?.clusters.filter(c => c.auth_status === "unauthorized").map(c => c.name) || []
+  const authWarningPane = unauthorizedClusters.length === 0 ? null : (
+    <Alert variant="warning" isInline
+      title={`The Kubernetes API server rejected the dashboard's credentials for cluster ${unauthorizedClusters.join(", ")}.  Check the kubeconfig; it is reloaded automatically when it changes.`}/>
+  )
+  const staleCaches = dashboardInfo?.clusters.reduce((acc: string[], c) =>
+    acc.concat((c.cache || []).filter(cs => cs.stale).map(cs => `${cs.resource} in cluster ${c.name}`)), []) || []
+  const staleWarningPane = staleCaches.length === 0 ? null : (
+    <Alert variant="warning" isInline
+      title={`The dashboard has lost touch with the Kubernetes API for ${staleCaches.join(", ")}, so what is shown may be out of date.`}/>
+  )
   const version = (document.querySelector('meta[name="version"]') as HTMLMetaElement)?.content || "unknown"
   const chopRelease = (document.querySelector('meta[name="chop-release"]') as HTMLMetaElement)?.content || "unknown"
   return (
@@ -81,6 +118,8 @@ export const Dashboard: React.FunctionComponent = () => {
       ) : (
         <React.Fragment>
           {retrieveErrorPane}
+          {authWarningPane}
+          {staleWarningPane}
           <Grid hasGutter={true} lg={4} md={6} sm={12}>
             <GridItem>
               <Card>
@@ -102,13 +141,14 @@ export const Dashboard: React.FunctionComponent = () => {
                     </DescriptionListGroup>
                     <DescriptionListGroup>
                       <DescriptionListTerm>
-                        Kubernetes Cluster
+                        {dashboardInfo && dashboardInfo.clusters.length > 1 ? "Default Kubernetes Cluster" : "Kubernetes Cluster"}
                       </DescriptionListTerm>
                       <DescriptionListDescription>
                         {dashboardInfo ? (
                           <PageSection>
                             <div>k8s api: {dashboardInfo.kube_cluster}</div>
                             <div>k8s version: {dashboardInfo.kube_version}</div>
+                            <div>k8s auth: {dashboardInfo.auth_status}</div>
                           </PageSection>
                         ) : "unknown"}
                       </DescriptionListDescription>
@@ -166,6 +206,26 @@ export const Dashboard: React.FunctionComponent = () => {
               </Card>
             </GridItem>
           </Grid>
+          {dashboardInfo && dashboardInfo.clusters.length > 1 ? (
+            <Card className="padded-card">
+              <CardTitle>Clusters</CardTitle>
+              <CardBody>
+                <ExpandableTable
+                  keyPrefix="dashboard-clusters"
+                  table_variant="compact"
+                  data={dashboardInfo.clusters.map(c => ({
+                    ...c,
+                    operators: `${c.chop_count_available} of ${c.chop_count} available`,
+                    chis: `${c.chi_count_complete} of ${c.chi_count} complete`,
+                  }))}
+                  columns={['Cluster', 'Kubernetes API', 'Kubernetes Version', 'Auth', 'ClickHouse Operators', 'ClickHouse Installations']}
+                  column_fields={['name', 'kube_cluster', 'kube_version', 'auth_status', 'operators', 'chis']}
+                  warnings={dashboardInfo.clusters.map((c): Array<WarningType>|undefined =>
+                    c.error ? [{variant: 'error', text: c.error}] : undefined)}
+                />
+              </CardBody>
+            </Card>
+          ) : null}
           <TextContent>
             <Bullseye className="padded-bullseye">
               <Text component={TextVariants.small}>
@@ -2,10 +2,14 @@ import * as React from 'react';
 import { PageSection, Title } from '@patternfly/react-core';
 import SwaggerUI from "swagger-ui-react"
 import "swagger-ui-react/swagger-ui.css";
+import { csrfHeader, csrfToken } from '@app/utils/csrfToken';
 
 export const Devel: React.FunctionComponent = () => (
     <PageSection>
       <Title headingLevel="h1" size="lg">Developer Tools</Title>
-      <SwaggerUI url="/apidocs.json" />
+      <SwaggerUI url="apidocs.json" requestInterceptor={(req) => {
+        req.headers[csrfHeader] = csrfToken()
+        return req
+      }} />
     </PageSection>
 )
@@ -10,6 +10,7 @@ export interface Namespace {
 export const NamespaceSelector: React.FunctionComponent<
   {
     onSelect?: (selected: string) => void
+    initialSelected?: string
   }> = (props) => {
 
   const [namespaces, setNamespaces] = useState(new Array<Namespace>())
@@ -35,6 +36,7 @@ export const NamespaceSelector: React.FunctionComponent<
     <ListSelector
       listValues={namespaces.map((value) => (value.name))}
       onSelect={onSelect}
+      initialSelected={props.initialSelected}
     />
   )
 }
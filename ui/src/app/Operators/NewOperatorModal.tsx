@@ -3,6 +3,7 @@ import { ToggleModalSubProps } from '@app/Components/ToggleModal';
 import { useContext, useState } from 'react';
 import { AlertVariant, Bullseye, Button, Grid, GridItem, Modal, ModalVariant, TextInput } from '@patternfly/react-core';
 import { NamespaceSelector } from '@app/Namespaces/NamespaceSelector';
+import { defaultNamespace } from '@app/utils/defaultNamespace';
 import { fetchWithErrorHandling } from '@app/utils/fetchWithErrorHandling';
 import { AddAlertContext } from '@app/utils/alertContext';
 
@@ -16,7 +17,7 @@ export const NewOperatorModal: React.FunctionComponent<NewOperatorModalProps> =
   const [selectedVersion, setSelectedVersion] = useState("")
   let selectedNamespace: string
   let setSelectedNamespace: (string) => void
-  const [selectedNamespaceState, setSelectedNamespaceState] = useState("")
+  const [selectedNamespaceState, setSelectedNamespaceState] = useState(defaultNamespace())
   const addAlert = useContext(AddAlertContext)
   if (props.namespace) {
     selectedNamespace = props.namespace
@@ -27,7 +28,7 @@ export const NewOperatorModal: React.FunctionComponent<NewOperatorModalProps> =
   }
   const closeModal = (): void => {
     setSelectedVersion("")
-    setSelectedNamespace("")
+    setSelectedNamespace(defaultNamespace())
     props.closeModal()
   }
   const onDeployClick = (): void => {
@@ -44,7 +45,8 @@ export const NewOperatorModal: React.FunctionComponent<NewOperatorModalProps> =
     )
     closeModal()
   }
-  const latestChop = (document.querySelector('meta[name="chop-release"]') as HTMLMetaElement)?.content || "latest"
+  const latestChop = (document.querySelector('meta[name="operator-version"]') as HTMLMetaElement)?.content ||
+    (document.querySelector('meta[name="chop-release"]') as HTMLMetaElement)?.content || "latest"
   return (
     <Modal
       title={(props.isUpgrade ? "Upgrade" : "Deploy") + " ClickHouse Operator"}
@@ -83,7 +85,7 @@ export const NewOperatorModal: React.FunctionComponent<NewOperatorModalProps> =
             (
               <GridItem span={7}>
                 Select a Namespace:
-                <NamespaceSelector onSelect={setSelectedNamespace}/>
+                <NamespaceSelector onSelect={setSelectedNamespace} initialSelected={defaultNamespace()}/>
               </GridItem>
             )
         }
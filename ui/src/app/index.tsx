@@ -23,7 +23,7 @@ const App: React.FunctionComponent = () => {
     setAlerts([...alerts.filter(a => a.key !== key)])
   }
   return (
-    <Router>
+    <Router basename={new URL(document.baseURI).pathname.replace(/\/$/, '')}>
       <AddAlertContextProvider value={addAlert}>
         <AppLayout>
           <AlertGroup isToast isLiveRegion>
@@ -0,0 +1,31 @@
+// The cluster chosen in the UI is remembered in local storage, and API calls for cluster resources are sent
+// to /api/v1/clusters/<cluster>/...  If no cluster is chosen, the server's default cluster is used.
+const clusterKey = 'adash-cluster'
+
+const clusterScopedPath = /^\/?api\/v1\/(chis|operators|namespaces)(\/|$)/
+
+export interface ClusterInfo {
+  name: string
+  kube_cluster: string
+  default: boolean
+}
+
+export function selectedCluster(): string {
+  return window.localStorage.getItem(clusterKey) || ""
+}
+
+export function setSelectedCluster(name: string) {
+  if (name) {
+    window.localStorage.setItem(clusterKey, name)
+  } else {
+    window.localStorage.removeItem(clusterKey)
+  }
+}
+
+export function clusterScopedURL(url: string): string {
+  const cluster = selectedCluster()
+  if (!cluster || !clusterScopedPath.test(url)) {
+    return url
+  }
+  return url.replace(/^(\/?)api\/v1\//, `$1api/v1/clusters/${encodeURIComponent(cluster)}/`)
+}
@@ -0,0 +1,6 @@
+// The server injects a CSRF token into index.html, which must be sent with state-changing API calls
+export const csrfHeader = 'X-CSRF-Token'
+
+export function csrfToken(): string {
+  return (document.querySelector('meta[name="csrf-token"]') as HTMLMetaElement)?.content || ""
+}
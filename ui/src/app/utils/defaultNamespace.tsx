@@ -0,0 +1,4 @@
+// The server injects the namespace to preselect when deploying operators and creating CHIs into index.html
+export function defaultNamespace(): string {
+  return (document.querySelector('meta[name="default-namespace"]') as HTMLMetaElement)?.content || ""
+}
@@ -1,3 +1,6 @@
+import { csrfHeader, csrfToken } from '@app/utils/csrfToken';
+import { clusterScopedURL } from '@app/utils/cluster';
+
 export function fetchWithErrorHandling(url: string, method: string, body?: object,
                                        onSuccess?: (response: Response, body: object|string|undefined) => number|void,
                                        onFailure?: (response: Response, text: string, error: string) => number|void,
@@ -21,7 +24,8 @@ export function fetchWithErrorHandling(url: string, method: string, body?: objec
     method: method,
     headers: {
       'Accept': 'application/json',
-      'Content-Type': 'application/json'
+      'Content-Type': 'application/json',
+      [csrfHeader]: csrfToken()
     },
   }
   if (body !== undefined) {
@@ -30,7 +34,9 @@ export function fetchWithErrorHandling(url: string, method: string, body?: objec
   let response: Response
   let text: string
   let responseBody: object|string|undefined
-  fetch(url, fetchInit)
+  // Resolve URLs relative to the <base href>, so the dashboard works when served under a base path, and send
+  // requests for cluster resources to the cluster chosen in the UI
+  fetch(clusterScopedURL(url).replace(/^\//, ''), fetchInit)
   .then(resp => {
     response = resp
     return resp.text()
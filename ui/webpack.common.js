@@ -4,7 +4,8 @@ const CopyPlugin = require('copy-webpack-plugin');
 const TsconfigPathsPlugin = require('tsconfig-paths-webpack-plugin');
 const Dotenv = require('dotenv-webpack');
 const BG_IMAGES_DIRNAME = 'bgimages';
-const ASSET_PATH = process.env.ASSET_PATH || '/';
+// Assets are loaded relative to the <base href>, which the server sets to its base path
+const ASSET_PATH = process.env.ASSET_PATH || '';
 const webpack = require('webpack');
 module.exports = env => {
 